@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"reverseproxy/trackers/active"
+	"testing"
+	"time"
+)
+
+func TestIPConnections(t *testing.T) {
+	connections := active.NewIPConnections(2, 0)
+	ctx := context.Background()
+
+	release1, ok1 := connections.Acquire(ctx, "192.0.2.1")
+	release2, ok2 := connections.Acquire(ctx, "192.0.2.1")
+	if !ok1 || !ok2 {
+		t.Fatalf("Acquire() should allow 2 concurrent requests")
+	}
+	if _, ok := connections.Acquire(ctx, "192.0.2.1"); ok {
+		t.Errorf("Acquire() = true over the cap")
+	}
+	if release, ok := connections.Acquire(ctx, "192.0.2.2"); !ok {
+		t.Errorf("Acquire() of another ip should not be capped")
+	} else {
+		release()
+	}
+	if active := connections.GetActiveConnections(); active["192.0.2.1"] != 2 || len(active) != 1 {
+		t.Errorf("GetActiveConnections() = %v, want 192.0.2.1: 2", active)
+	}
+
+	release1()
+	release2()
+	if active := connections.GetActiveConnections(); len(active) != 0 {
+		t.Errorf("GetActiveConnections() = %v, want none once released", active)
+	}
+	if queued, rejected := connections.Stats(); queued != 0 || rejected != 1 {
+		t.Errorf("Stats() = %d, %d, want 0 queued and 1 rejected", queued, rejected)
+	}
+}
+
+func TestIPConnectionsQueue(t *testing.T) {
+	connections := active.NewIPConnections(1, 200*time.Millisecond)
+	ctx := context.Background()
+
+	release, _ := connections.Acquire(ctx, "192.0.2.1")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release()
+	}()
+	start := time.Now()
+	queuedRelease, ok := connections.Acquire(ctx, "192.0.2.1")
+	if !ok || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("Acquire() = %v after %s, want a slot once the first request is done", ok, time.Since(start))
+	}
+
+	// the slot is held until the queue timeout
+	if _, ok := connections.Acquire(ctx, "192.0.2.1"); ok {
+		t.Errorf("Acquire() = true while the slot stays held")
+	}
+	queuedRelease()
+	if queued, rejected := connections.Stats(); queued != 2 || rejected != 1 {
+		t.Errorf("Stats() = %d, %d, want 2 queued and 1 rejected", queued, rejected)
+	}
+
+	disabled := active.NewIPConnections(0, 0)
+	for i := 0; i < 10; i++ {
+		if _, ok := disabled.Acquire(ctx, "192.0.2.1"); !ok {
+			t.Fatalf("Acquire() = false without cap")
+		}
+	}
+}
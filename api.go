@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reverseproxy/trackers/ip"
+	"strings"
+	"time"
+)
+
+// BanRequest is the body of a manual ban
+type BanRequest struct {
+	// Target is an ip or a CIDR range
+	Target string `json:"target"`
+	// Duration like "30m" or "24h"
+	Duration string `json:"duration"`
+	Comment  string `json:"comment"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to encode JSON: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// bansHandler lists the bans (GET), bans an ip or range (POST) and unbans one (DELETE ?target=)
+func bansHandler(tracker *ip.IPTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, tracker.ListBans())
+		case http.MethodPost:
+			var request BanRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+				return
+			}
+			duration, err := time.ParseDuration(request.Duration)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid duration: "+err.Error())
+				return
+			}
+			target, ban, err := tracker.ManualBan(request.Target, duration, request.Comment)
+			if errors.Is(err, ip.ErrAllowed) {
+				writeJSONError(w, http.StatusConflict, target+": "+err.Error())
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, ip.BanEntry{Target: target, Kind: banKind(target), Ban: ban})
+		case http.MethodDelete:
+			unban(tracker, w, r.URL.Query().Get("target"))
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+func banKind(target string) string {
+	if strings.Contains(target, "/") {
+		return "range"
+	}
+	return "ip"
+}
+
+func unban(tracker *ip.IPTracker, w http.ResponseWriter, target string) {
+	if target == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing target")
+		return
+	}
+	if !tracker.Unban(target) {
+		writeJSONError(w, http.StatusNotFound, target+" is not banned")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"unbanned": target})
+}
+
+// unbanHandler unbans the ip of the ?ip= parameter, or everybody without it
+func unbanHandler(tracker *ip.IPTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if target := r.URL.Query().Get("ip"); target != "" {
+			unban(tracker, w, target)
+			return
+		}
+		tracker.UnbanAll()
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("All IPs have been unbanned."))
+	}
+}
+
+// resetHandler forgets the counters and offense history of the ip of the ?ip= parameter
+func resetHandler(tracker *ip.IPTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		target := r.URL.Query().Get("ip")
+		if target == "" {
+			writeJSONError(w, http.StatusBadRequest, "missing ip")
+			return
+		}
+		ip, tracked, err := tracker.ResetIP(target)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !tracked {
+			writeJSONError(w, http.StatusNotFound, ip+" is not tracked")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"reset": ip})
+	}
+}
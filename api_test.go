@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reverseproxy/ipranges"
+	"reverseproxy/trackers/ip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doRequest(handler http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder
+}
+
+func TestBansAPI(t *testing.T) {
+	tracker := ip.NewIPTracker(1, time.Minute, time.Minute)
+	allow, _ := ipranges.ParseList("10.9.0.0/16")
+	tracker.SetAccessLists(allow, nil)
+	handler := bansHandler(tracker)
+
+	response := doRequest(handler, "POST", "/__banme/api/bans", `{"target": "192.0.2.1", "duration": "1h", "comment": "scanner"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("POST ip ban status = %d, body %s", response.Code, response.Body)
+	}
+	var created ip.BanEntry
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.Target != "192.0.2.1" || created.Kind != "ip" || created.Comment != "scanner" || created.Reason != ip.ReasonManual {
+		t.Errorf("created ban = %+v", created)
+	}
+	if !tracker.CheckBan("192.0.2.1") {
+		t.Errorf("192.0.2.1 should be banned")
+	}
+
+	response = doRequest(handler, "POST", "/__banme/api/bans", `{"target": "198.51.100.0/24", "duration": "30m"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("POST range ban status = %d, body %s", response.Code, response.Body)
+	}
+	if !tracker.CheckBan("198.51.100.77") {
+		t.Errorf("198.51.100.77 should be banned by the range")
+	}
+
+	for _, invalid := range []string{`{"target": "nope", "duration": "1h"}`, `{"target": "192.0.2.2", "duration": "soon"}`, `{"target": "192.0.2.2", "duration": "-1h"}`, `not json`} {
+		if response := doRequest(handler, "POST", "/__banme/api/bans", invalid); response.Code != http.StatusBadRequest {
+			t.Errorf("POST %s status = %d, want 400", invalid, response.Code)
+		}
+	}
+	if response := doRequest(handler, "POST", "/__banme/api/bans", `{"target": "10.9.1.1", "duration": "1h"}`); response.Code != http.StatusConflict {
+		t.Errorf("POST allowed ip status = %d, want 409", response.Code)
+	}
+
+	response = doRequest(handler, "GET", "/__banme/api/bans", "")
+	var bans []ip.BanEntry
+	json.Unmarshal(response.Body.Bytes(), &bans)
+	if len(bans) != 2 || bans[0].Target != "198.51.100.0/24" || bans[0].Kind != "range" || bans[1].Target != "192.0.2.1" {
+		t.Errorf("GET bans = %+v, want the range then the ip", bans)
+	}
+
+	if response := doRequest(handler, "DELETE", "/__banme/api/bans?target=198.51.100.0/24", ""); response.Code != http.StatusOK {
+		t.Errorf("DELETE range status = %d", response.Code)
+	}
+	if tracker.CheckBan("198.51.100.77") {
+		t.Errorf("198.51.100.77 should be unbanned with its range")
+	}
+	if response := doRequest(handler, "DELETE", "/__banme/api/bans?target=198.51.100.0/24", ""); response.Code != http.StatusNotFound {
+		t.Errorf("DELETE unknown ban status = %d, want 404", response.Code)
+	}
+	if response := doRequest(handler, "PUT", "/__banme/api/bans", ""); response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT status = %d, want 405", response.Code)
+	}
+}
+
+func TestUnbanAPI(t *testing.T) {
+	tracker := ip.NewIPTracker(0, time.Minute, time.Minute)
+	tracker.IncrementHit("192.0.2.1")
+	tracker.IncrementHit("192.0.2.2")
+	handler := unbanHandler(tracker)
+
+	if response := doRequest(handler, "POST", "/__banme/api/unban?ip=192.0.2.1", ""); response.Code != http.StatusOK {
+		t.Errorf("unban single ip status = %d", response.Code)
+	}
+	if tracker.CheckBan("192.0.2.1") || !tracker.CheckBan("192.0.2.2") {
+		t.Errorf("only 192.0.2.1 should be unbanned")
+	}
+
+	doRequest(handler, "POST", "/__banme/api/unban", "")
+	if tracker.CheckBan("192.0.2.2") {
+		t.Errorf("unban without ip should unban everybody")
+	}
+}
+
+func TestResetAPI(t *testing.T) {
+	tracker := ip.NewIPTracker(10, time.Minute, time.Minute)
+	tracker.IncrementHit("192.0.2.1")
+	tracker.IncrementStatus("192.0.2.1", 404)
+	handler := resetHandler(tracker)
+
+	if response := doRequest(handler, "POST", "/__banme/api/reset?ip=192.0.2.1", ""); response.Code != http.StatusOK {
+		t.Errorf("reset status = %d", response.Code)
+	}
+	if hits := tracker.GetHits("192.0.2.1"); hits != 0 {
+		t.Errorf("GetHits() after reset = %d, want 0", hits)
+	}
+	if response := doRequest(handler, "POST", "/__banme/api/reset?ip=192.0.2.1", ""); response.Code != http.StatusNotFound {
+		t.Errorf("reset of an unknown ip status = %d, want 404", response.Code)
+	}
+	if response := doRequest(handler, "GET", "/__banme/api/reset?ip=192.0.2.1", ""); response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET reset status = %d, want 405", response.Code)
+	}
+
+	// the ip is normalized like for the bans
+	tracker.IncrementHit("192.0.2.3")
+	tracker.IncrementHit("2001:db8::a")
+	for _, target := range []string{"::ffff:192.0.2.3", "2001:DB8::A"} {
+		if response := doRequest(handler, "POST", "/__banme/api/reset?ip="+url.QueryEscape(target), ""); response.Code != http.StatusOK {
+			t.Errorf("reset of %s status = %d, want 200", target, response.Code)
+		}
+	}
+	if hits := tracker.GetHits("2001:db8::a"); hits != 0 {
+		t.Errorf("GetHits() after reset = %d, want 0", hits)
+	}
+	for _, target := range []string{"not-an-ip", "192.0.2.0/24"} {
+		if response := doRequest(handler, "POST", "/__banme/api/reset?ip="+url.QueryEscape(target), ""); response.Code != http.StatusBadRequest {
+			t.Errorf("reset of %s status = %d, want 400", target, response.Code)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reverseproxy/trackers/backendcost"
+	"testing"
+	"time"
+)
+
+func TestBackendBudget(t *testing.T) {
+	budget := backendcost.NewBudget(time.Minute, 0.5, 10)
+
+	budget.Record("192.0.2.1", 6)
+	if over, _ := budget.OverBudget("192.0.2.1"); over {
+		t.Errorf("OverBudget() = true below -backend-budget-min-seconds")
+	}
+	budget.Record("192.0.2.2", 2)
+	budget.Record("192.0.2.3", 2)
+	budget.Record("192.0.2.1", 2)
+	over, retryAfter := budget.OverBudget("192.0.2.1")
+	if !over || retryAfter <= 0 || retryAfter > time.Minute {
+		t.Errorf("OverBudget() = %v, %v, want true with 8s of 12s", over, retryAfter)
+	}
+	if over, _ := budget.OverBudget("192.0.2.2"); over {
+		t.Errorf("OverBudget() = true for an ip with 2s of 12s")
+	}
+
+	top := budget.Top(2)
+	if len(top) != 2 || top[0].IP != "192.0.2.1" || top[0].Seconds != 8 || top[0].Requests != 2 {
+		t.Errorf("Top() = %+v", top)
+	}
+	if total, overBudget := budget.Info(); total != 12 || overBudget != 1 {
+		t.Errorf("Info() = %v, %v, want 12, 1", total, overBudget)
+	}
+
+	budget.Reset("192.0.2.1")
+	if over, _ := budget.OverBudget("192.0.2.1"); over {
+		t.Errorf("OverBudget() = true after Reset()")
+	}
+	if total, _ := budget.Info(); total != 4 {
+		t.Errorf("total = %v after Reset(), want the 4s of the other ips", total)
+	}
+
+	disabled := backendcost.NewBudget(time.Minute, 0, 0)
+	disabled.Record("192.0.2.1", 100)
+	if over, _ := disabled.OverBudget("192.0.2.1"); over {
+		t.Errorf("OverBudget() = true with the budget disabled")
+	}
+}
+
+func TestBackendBudgetWindow(t *testing.T) {
+	budget := backendcost.NewBudget(50*time.Millisecond, 0.5, 0)
+	budget.Record("192.0.2.1", 5)
+	time.Sleep(60 * time.Millisecond)
+	budget.Record("192.0.2.2", 1)
+	if top := budget.Top(10); len(top) != 1 || top[0].IP != "192.0.2.2" || top[0].Share != 1 {
+		t.Errorf("Top() = %+v, want only the request in the window", top)
+	}
+	if total, _ := budget.Info(); total != 1 {
+		t.Errorf("total = %v, want 1", total)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reverseproxy/rules"
+	"reverseproxy/tarpit"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// StatusDrop closes the connection without any response, like the 444 of nginx
+const StatusDrop = 444
+
+// defaultBlockTemplate is the block page used without -block-template
+const defaultBlockTemplate = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <title>Access denied</title>
+  </head>
+  <body>
+    <h1>Access denied</h1>
+    <p>Your address {{.IP}} has been blocked after suspicious requests.</p>
+    {{if not .Until.IsZero}}<p>The block ends at {{.Until.Format "2006-01-02 15:04:05 MST"}}, in {{.Remaining}}.</p>{{end}}
+    <p>If you think this is a mistake, contact the support with the reference <code>{{.Reference}}</code>.</p>
+  </body>
+</html>
+`
+
+// BlockInfo is what the block templates can show
+type BlockInfo struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+	// Remaining is the rounded time until the end of the ban, "" for blocks and denied ips
+	Remaining string `json:"remaining,omitempty"`
+	// Reference identifies the response in the access log for the support
+	Reference string `json:"reference"`
+}
+
+// retryAfter returns the seconds until the end of the ban, 0 when there is no end
+func (i BlockInfo) retryAfter() int {
+	if i.Until.IsZero() {
+		return 0
+	}
+	return max(int(math.Ceil(time.Until(i.Until).Seconds())), 1)
+}
+
+// BlockPage answers the banned and blocked clients with a status and a template
+type BlockPage struct {
+	Status      int
+	contentType string
+	render      func(io.Writer, BlockInfo) error
+}
+
+// NewBlockPage parses the template, json templates (by extension) are rendered as text with a json
+// function to escape the values, the others as html. An empty path uses the default html page.
+func NewBlockPage(status int, path string) (*BlockPage, error) {
+	switch status {
+	case http.StatusForbidden, http.StatusTooManyRequests, StatusDrop:
+	default:
+		return nil, fmt.Errorf("unsupported block status %d, expected 403, 429 or 444", status)
+	}
+	page := &BlockPage{Status: status, contentType: "text/html; charset=utf-8"}
+	content := defaultBlockTemplate
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		content = string(raw)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		tmpl, err := texttemplate.New("block").Funcs(texttemplate.FuncMap{"json": toJSON}).Parse(content)
+		if err != nil {
+			return nil, err
+		}
+		page.contentType = "application/json"
+		page.render = func(w io.Writer, info BlockInfo) error { return tmpl.Execute(w, info) }
+		return page, nil
+	}
+	tmpl, err := htmltemplate.New("block").Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	page.render = func(w io.Writer, info BlockInfo) error { return tmpl.Execute(w, info) }
+	return page, nil
+}
+
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// Write sends the block page, or drops the connection for StatusDrop
+func (p *BlockPage) Write(w http.ResponseWriter, info BlockInfo) {
+	if p.Status == StatusDrop {
+		dropConnection(w)
+		return
+	}
+	if retryAfter := info.retryAfter(); retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(p.Status)
+	p.render(w, info)
+}
+
+// dropConnection closes the connection without writing anything
+func dropConnection(w http.ResponseWriter) {
+	if hijacker, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	// the server aborts the response and closes the connection
+	panic(http.ErrAbortHandler)
+}
+
+// newReference returns a random id to find a block response in the access log
+func newReference() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newBlockInfo describes the ban (or block when until is zero) of the ip for the block page
+func newBlockInfo(clientIP string, reason string, until time.Time) BlockInfo {
+	info := BlockInfo{IP: clientIP, Reason: reason, Until: until, Reference: newReference()}
+	if !until.IsZero() {
+		info.Remaining = time.Until(until).Round(time.Second).String()
+	}
+	return info
+}
+
+// responseFor returns the response of the rule behind the ban or block reason, or the fallback
+func responseFor(engine *rules.Engine, reason string, fallback rules.Response) rules.Response {
+	if name, ok := strings.CutPrefix(reason, "rule:"); ok {
+		if rule := engine.Find(name); rule != nil && rule.Response != "" {
+			return rule.Response
+		}
+	}
+	return fallback
+}
+
+// refuse answers a banned or blocked client, tarpit responses fall back to the block page when the tarpit
+// is full. It returns the response actually sent.
+func refuse(w http.ResponseWriter, r *http.Request, pit *tarpit.Tarpit, page *BlockPage, response rules.Response, info BlockInfo) rules.Response {
+	switch response {
+	case rules.ResponseTarpit:
+		status := page.Status
+		if status == StatusDrop {
+			status = http.StatusForbidden
+		}
+		if retryAfter := info.retryAfter(); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+		if pit.Drip(w, r, status) {
+			return response
+		}
+	case rules.ResponseDelay:
+		if pit.DelayResponse(r, func() { page.Write(w, info) }) {
+			return response
+		}
+	}
+	page.Write(w, info)
+	return rules.ResponseForbidden
+}
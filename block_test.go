@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reverseproxy/rules"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockPage(t *testing.T) {
+	page, err := NewBlockPage(http.StatusTooManyRequests, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := newBlockInfo("192.0.2.1", "threshold", time.Now().Add(90*time.Second))
+	recorder := httptest.NewRecorder()
+	page.Write(recorder, info)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", recorder.Code)
+	}
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "90" {
+		t.Errorf("Retry-After = %q, want 90", retryAfter)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, info.Reference) || !strings.Contains(body, "192.0.2.1") || !strings.Contains(body, "1m30s") {
+		t.Errorf("block page should show the ip, the remaining time and the reference: %s", body)
+	}
+
+	// blocks without ban have no end nor Retry-After
+	recorder = httptest.NewRecorder()
+	page.Write(recorder, newBlockInfo("192.0.2.1", "rule:admin", time.Time{}))
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "" {
+		t.Errorf("Retry-After = %q, want none for a block", retryAfter)
+	}
+
+	if _, err := NewBlockPage(http.StatusTeapot, ""); err == nil {
+		t.Errorf("NewBlockPage() with status 418 should fail")
+	}
+}
+
+func TestBlockPageJSONTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "block.json")
+	os.WriteFile(path, []byte(`{"error": "banned", "until": {{json .Until}}, "reference": {{json .Reference}}, "reason": {{json .Reason}}}`), 0o644)
+	page, err := NewBlockPage(http.StatusForbidden, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	info := newBlockInfo("192.0.2.1", `rule:"quoted"`, time.Now().Add(time.Hour))
+	page.Write(recorder, info)
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid json %s: %v", recorder.Body, err)
+	}
+	if body["reference"] != info.Reference || body["reason"] != `rule:"quoted"` {
+		t.Errorf("body = %v", body)
+	}
+}
+
+func TestBlockPageDrop(t *testing.T) {
+	page, err := NewBlockPage(StatusDrop, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refuse(w, r, nil, page, rules.ResponseForbidden, newBlockInfo("192.0.2.1", "threshold", time.Now().Add(time.Minute)))
+	}))
+	defer server.Close()
+	if response, err := http.Get(server.URL); err == nil {
+		response.Body.Close()
+		t.Errorf("GET = %d, want the connection closed without response", response.StatusCode)
+	}
+}
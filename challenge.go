@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"reverseproxy/challenge"
+	"reverseproxy/trackers/ip"
+	"strings"
+)
+
+const (
+	challengePath       = "/__banme/challenge"
+	challengeVerifyPath = "/__banme/challenge/verify"
+)
+
+// localRedirect keeps only the local paths to avoid open redirects, "/" otherwise
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// redirectToChallenge sends a grey zone client to the challenge page, coming back to the request url once solved
+func redirectToChallenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, challengePath+"?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+}
+
+// challengePageHandler serves the proof of work page, it is public like the pages it protects
+func challengePageHandler(challenger *challenge.Challenger, resolver *ClientIPResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		challenger.WritePage(w, resolver.ClientIP(r), challengeVerifyPath, localRedirect(r.URL.Query().Get("redirect")))
+	}
+}
+
+// challengeVerifyHandler checks the solution, then sets the pass cookie and resets the score of the ip
+func challengeVerifyHandler(challenger *challenge.Challenger, tracker *ip.IPTracker, resolver *ClientIPResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		clientIP := resolver.ClientIP(r)
+		redirect := localRedirect(r.PostFormValue("redirect"))
+		pass, err := challenger.Verify(clientIP, r.PostFormValue("challenge"), r.PostFormValue("solution"))
+		if err != nil {
+			log.Printf("Challenge failed: ip=%s error=%v", clientIP, err)
+			http.Redirect(w, r, challengePath+"?redirect="+url.QueryEscape(redirect), http.StatusSeeOther)
+			return
+		}
+		log.Printf("Challenge solved: ip=%s", clientIP)
+		tracker.ResetScore(clientIP)
+		http.SetCookie(w, challenger.Cookie(r, pass))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,141 @@
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CookieName is the cookie letting the clients which solved a challenge through
+const CookieName = "banme_pass"
+
+// challengeTTL is how long a client has to solve a challenge
+const challengeTTL = 5 * time.Minute
+
+// errors of Verify
+var (
+	ErrInvalid  = errors.New("invalid challenge")
+	ErrExpired  = errors.New("expired challenge")
+	ErrSolution = errors.New("wrong solution")
+)
+
+// Challenger issues proof of work challenges and the signed cookies proving they were solved.
+//
+// Everything is stateless: challenges and cookies carry the client ip and their expiry, signed
+// with an HMAC of the secret, so they can't be forged nor reused from another ip.
+type Challenger struct {
+	secret []byte
+	// Difficulty is the number of leading zero bits required in sha256(challenge + ":" + solution)
+	Difficulty int
+	// TTL is the validity of the cookie once the challenge is solved
+	TTL time.Duration
+}
+
+// New signs with secret, a random one is generated when empty (cookies are then lost on restart)
+func New(secret string, difficulty int, ttl time.Duration) *Challenger {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &Challenger{secret: key, Difficulty: difficulty, TTL: ttl}
+}
+
+// sign returns "payload.signature"
+func (c *Challenger) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and that the token is for the ip and not expired
+func (c *Challenger) verify(token string, kind string, ip string, now time.Time) error {
+	// the signature has no dot, unlike the ips of the payload
+	dot := strings.LastIndex(token, ".")
+	if dot < 0 {
+		return ErrInvalid
+	}
+	payload := token[:dot]
+	if !hmac.Equal([]byte(c.sign(payload)), []byte(token)) {
+		return ErrInvalid
+	}
+	// kind|ip|expiry|random
+	parts := strings.Split(payload, "|")
+	if len(parts) != 4 || parts[0] != kind || parts[1] != ip {
+		return ErrInvalid
+	}
+	expiry, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if now.Unix() > expiry {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (c *Challenger) issue(kind string, ip string, ttl time.Duration) string {
+	random := make([]byte, 9)
+	rand.Read(random)
+	payload := fmt.Sprintf("%s|%s|%d|%s", kind, ip, time.Now().Add(ttl).Unix(), base64.RawURLEncoding.EncodeToString(random))
+	return c.sign(payload)
+}
+
+// Issue returns a new challenge for the ip
+func (c *Challenger) Issue(ip string) string {
+	return c.issue("challenge", ip, challengeTTL)
+}
+
+// Verify checks the solution of the challenge and returns the cookie value granting access to the ip
+func (c *Challenger) Verify(ip string, challenge string, solution string) (string, error) {
+	if err := c.verify(challenge, "challenge", ip, time.Now()); err != nil {
+		return "", err
+	}
+	if LeadingZeroBits(challenge, solution) < c.Difficulty {
+		return "", ErrSolution
+	}
+	return c.issue("pass", ip, c.TTL), nil
+}
+
+// HasPass reports if the request carries a valid cookie for the ip
+func (c *Challenger) HasPass(r *http.Request, ip string) bool {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		return false
+	}
+	return c.verify(cookie.Value, "pass", ip, time.Now()) == nil
+}
+
+// Cookie returns the cookie to set for the pass
+func (c *Challenger) Cookie(r *http.Request, pass string) *http.Cookie {
+	return &http.Cookie{
+		Name:     CookieName,
+		Value:    pass,
+		Path:     "/",
+		MaxAge:   int(c.TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// LeadingZeroBits counts the leading zero bits of sha256(challenge + ":" + solution)
+func LeadingZeroBits(challenge string, solution string) int {
+	sum := sha256.Sum256([]byte(challenge + ":" + solution))
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+	return zeros
+}
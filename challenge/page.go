@@ -0,0 +1,52 @@
+package challenge
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed solver.js
+var solverJS string
+
+var pageTemplate = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta name="robots" content="noindex" />
+    <title>Checking your browser</title>
+  </head>
+  <body>
+    <h1>Checking your browser</h1>
+    <p id="status">This takes a few seconds, you will be redirected automatically.</p>
+    <noscript><p>Please enable javascript to continue.</p></noscript>
+    <form id="challenge" method="POST" action="{{.VerifyPath}}">
+      <input type="hidden" name="challenge" value="{{.Challenge}}" />
+      <input type="hidden" name="solution" value="" />
+      <input type="hidden" name="redirect" value="{{.Redirect}}" />
+    </form>
+    <script>
+{{.Solver}}
+      setTimeout(function () {
+        const form = document.getElementById("challenge");
+        form.solution.value = solve(form.challenge.value, {{.Difficulty}});
+        form.submit();
+      }, 50);
+    </script>
+  </body>
+</html>
+`))
+
+// WritePage serves the challenge page solving a new challenge for the ip then posting it to verifyPath
+func (c *Challenger) WritePage(w http.ResponseWriter, ip string, verifyPath string, redirect string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	pageTemplate.Execute(w, map[string]interface{}{
+		"Challenge":  c.Issue(ip),
+		"Difficulty": c.Difficulty,
+		"VerifyPath": verifyPath,
+		"Redirect":   redirect,
+		"Solver":     template.JS(solverJS),
+	})
+}
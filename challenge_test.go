@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"reverseproxy/challenge"
+	"reverseproxy/trackers/ip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solveChallenge brute forces the proof of work like the javascript of the page
+func solveChallenge(token string, difficulty int) string {
+	for n := 0; ; n++ {
+		if challenge.LeadingZeroBits(token, strconv.Itoa(n)) >= difficulty {
+			return strconv.Itoa(n)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	challenger := challenge.New("secret", 8, time.Hour)
+	token := challenger.Issue("192.0.2.1")
+	solution := solveChallenge(token, 8)
+
+	if _, err := challenger.Verify("192.0.2.2", token, solution); err != challenge.ErrInvalid {
+		t.Errorf("Verify() from another ip = %v, want ErrInvalid", err)
+	}
+	if _, err := challenger.Verify("192.0.2.1", strings.Replace(token, "192.0.2.1", "192.0.2.2", 1), solution); err != challenge.ErrInvalid {
+		t.Errorf("Verify() of a tampered challenge = %v, want ErrInvalid", err)
+	}
+	if _, err := challenge.New("other", 8, time.Hour).Verify("192.0.2.1", token, solution); err != challenge.ErrInvalid {
+		t.Errorf("Verify() with another secret = %v, want ErrInvalid", err)
+	}
+	wrong := solution + "0"
+	if challenge.LeadingZeroBits(token, wrong) < 8 {
+		if _, err := challenger.Verify("192.0.2.1", token, wrong); err != challenge.ErrSolution {
+			t.Errorf("Verify() of a wrong solution = %v, want ErrSolution", err)
+		}
+	}
+	pass, err := challenger.Verify("192.0.2.1", token, solution)
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(&http.Cookie{Name: challenge.CookieName, Value: pass})
+	if !challenger.HasPass(request, "192.0.2.1") {
+		t.Errorf("HasPass() = false with the cookie of the ip")
+	}
+	if challenger.HasPass(request, "192.0.2.2") {
+		t.Errorf("HasPass() = true with the cookie of another ip")
+	}
+	if challenger.HasPass(httptest.NewRequest("GET", "/", nil), "192.0.2.1") {
+		t.Errorf("HasPass() = true without cookie")
+	}
+
+	expired := challenge.New("secret", 8, -time.Minute)
+	pass, err = expired.Verify("192.0.2.1", token, solution)
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	request = httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(&http.Cookie{Name: challenge.CookieName, Value: pass})
+	if expired.HasPass(request, "192.0.2.1") {
+		t.Errorf("HasPass() = true with an expired cookie")
+	}
+}
+
+func TestChallengeHandlers(t *testing.T) {
+	challenger := challenge.New("secret", 8, time.Hour)
+	tracker := ip.NewIPTracker(10, time.Minute, time.Minute)
+	resolver := &ClientIPResolver{}
+	tracker.AddScore("192.0.2.1", 5)
+
+	page := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", challengePath+"?redirect=%2Fadmin%3Fa%3D1", nil)
+	request.RemoteAddr = "192.0.2.1:1234"
+	challengePageHandler(challenger, resolver).ServeHTTP(page, request)
+	body := page.Body.String()
+	start := strings.Index(body, `name="challenge" value="`) + len(`name="challenge" value="`)
+	token := body[start : start+strings.Index(body[start:], `"`)]
+	if !strings.Contains(body, `value="/admin?a=1"`) || !strings.Contains(body, "function solve") {
+		t.Fatalf("challenge page should embed the solver and the redirect: %s", body)
+	}
+
+	form := url.Values{"challenge": {token}, "solution": {solveChallenge(token, 8)}, "redirect": {"/admin?a=1"}}
+	request = httptest.NewRequest("POST", challengeVerifyPath, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.RemoteAddr = "192.0.2.1:1234"
+	verified := httptest.NewRecorder()
+	challengeVerifyHandler(challenger, tracker, resolver).ServeHTTP(verified, request)
+	if verified.Code != http.StatusSeeOther || verified.Header().Get("Location") != "/admin?a=1" {
+		t.Errorf("verify = %d to %q, want 303 to /admin?a=1", verified.Code, verified.Header().Get("Location"))
+	}
+	if cookies := verified.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != challenge.CookieName {
+		t.Errorf("verify cookies = %v", cookies)
+	}
+	if score := tracker.GetScore("192.0.2.1"); score != 0 {
+		t.Errorf("GetScore() after the challenge = %v, want 0", score)
+	}
+
+	for target, want := range map[string]string{"/path": "/path", "//evil.example": "/", "https://evil.example": "/", `/\evil.example`: "/"} {
+		if got := localRedirect(target); got != want {
+			t.Errorf("localRedirect(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestChallengeSolverJS(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	token := challenge.New("secret", 12, time.Hour).Issue("2001:db8::1")
+	script := `const { solve } = require("./challenge/solver.js"); process.stdout.write(solve(process.argv[1], 12));`
+	output, err := exec.Command(node, "-e", script, token).Output()
+	if err != nil {
+		t.Fatalf("node: %v", err)
+	}
+	if zeros := challenge.LeadingZeroBits(token, string(output)); zeros < 12 {
+		t.Errorf("javascript solution %q has %d leading zero bits, want 12", output, zeros)
+	}
+}
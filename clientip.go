@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"reverseproxy/ipranges"
+	"strings"
+)
+
+// ClientIPResolver extracts the client ip of a request, the forwarding headers are only
+// trusted when the request comes from one of the trusted proxies
+type ClientIPResolver struct {
+	TrustedProxies *ipranges.List
+	// Header is an optional vendor header set by the trusted proxy like CF-Connecting-IP
+	Header string
+	// Forwarded is the single forwarding header written by the trusted proxy, one of the
+	// Forwarded* constants, X-Forwarded-For when empty. The others are ignored as the proxy
+	// passes them through unchanged from the client.
+	Forwarded string
+}
+
+// The forwarding headers a trusted proxy can be configured to write
+const (
+	ForwardedXFF     = "x-forwarded-for"
+	ForwardedRFC7239 = "forwarded"
+	ForwardedXRealIP = "x-real-ip"
+)
+
+// IsForwardedHeader tells if name is one of the supported forwarding headers
+func IsForwardedHeader(name string) bool {
+	return name == ForwardedXFF || name == ForwardedRFC7239 || name == ForwardedXRealIP
+}
+
+// ClientIP returns the ip of the client: the vendor header if configured, otherwise the
+// right-most untrusted hop of the configured forwarding header, otherwise RemoteAddr
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr) // Extract the IP without the port
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	remoteIP = normalizeIP(remoteIP)
+	if c.TrustedProxies.Len() == 0 || !c.TrustedProxies.Contains(remoteIP) {
+		return remoteIP
+	}
+
+	if c.Header != "" {
+		if value := r.Header.Get(c.Header); value != "" {
+			if addr, ok := parseHop(value); ok {
+				return addr
+			}
+		}
+	}
+
+	var hops []string
+	switch c.Forwarded {
+	case ForwardedRFC7239:
+		hops = parseForwarded(r.Header.Values("Forwarded"))
+	case ForwardedXRealIP:
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			hops = []string{realIP}
+		}
+	default:
+		// X-Forwarded-For: <client>, <proxy1>, <proxy2>
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+	}
+
+	return c.rightMostUntrusted(hops, remoteIP)
+}
+
+// rightMostUntrusted walks the hops from the closest one, the first hop which is not a trusted
+// proxy is the client. Everything on its left could have been forged by the client.
+// An unparsable hop (for=unknown, obfuscated identifier, garbage) stops the walk on the last
+// trusted hop, a shared synthetic key would put unrelated clients in the same bucket.
+func (c *ClientIPResolver) rightMostUntrusted(hops []string, remoteIP string) string {
+	client := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			return client
+		}
+		client = addr
+		if !c.TrustedProxies.Contains(addr) {
+			return addr
+		}
+	}
+	return client
+}
+
+// parseForwarded extracts the for= parameters of RFC 7239 Forwarded headers
+func parseForwarded(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, value)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an ip optionally quoted, bracketed or followed by a port
+// like 192.0.2.1, "192.0.2.1:4711" or "[2001:db8::17]:4711"
+func parseHop(value string) (string, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", false
+	}
+	return addr.Unmap().String(), true
+}
+
+// normalizeIP unmaps ipv4 mapped ipv6 addresses so they are tracked as ipv4
+func normalizeIP(value string) string {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap().String()
+	}
+	return value
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reverseproxy/ipranges"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ipranges.ParseList("10.0.0.0/8,2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTrusted := &ClientIPResolver{TrustedProxies: trusted}
+	withHeader := &ClientIPResolver{TrustedProxies: trusted, Header: "CF-Connecting-IP"}
+	withForwarded := &ClientIPResolver{TrustedProxies: trusted, Forwarded: ForwardedRFC7239}
+	withRealIP := &ClientIPResolver{TrustedProxies: trusted, Forwarded: ForwardedXRealIP}
+	withoutTrusted := &ClientIPResolver{}
+
+	tests := []struct {
+		name       string
+		resolver   *ClientIPResolver
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"no proxy configured ignores headers", withoutTrusted, "203.0.113.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1"}, "203.0.113.1"},
+		{"untrusted remote ignores headers", withTrusted, "203.0.113.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1"}, "203.0.113.1"},
+		{"x-forwarded-for from trusted proxy", withTrusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1"}, "192.0.2.1"},
+		{"spoofed left-most entry is skipped", withTrusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 192.0.2.1, 10.0.0.2"}, "192.0.2.1"},
+		{"all hops trusted", withTrusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"x-real-ip", withRealIP, "10.0.0.1:1234", map[string]string{"X-Real-IP": "192.0.2.7"}, "192.0.2.7"},
+		{"x-real-ip not configured", withTrusted, "10.0.0.1:1234", map[string]string{"X-Real-IP": "192.0.2.7"}, "10.0.0.1"},
+		{"forwarded ipv6 with port", withForwarded, "10.0.0.1:1234", map[string]string{"Forwarded": `for=192.0.2.43, for="[2001:db9:cafe::17]:4711";proto=https`}, "2001:db9:cafe::17"},
+		{"forwarded unknown falls back to the proxy", withForwarded, "10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.1"},
+		{"unparsable hop stops on the last trusted hop", withTrusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1, garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"client forwarded ignored with x-forwarded-for", withTrusted, "10.0.0.1:1234", map[string]string{"Forwarded": "for=192.0.2.60", "X-Forwarded-For": "192.0.2.61"}, "192.0.2.61"},
+		{"client x-forwarded-for ignored with forwarded", withForwarded, "10.0.0.1:1234", map[string]string{"Forwarded": "for=192.0.2.60", "X-Forwarded-For": "192.0.2.61"}, "192.0.2.60"},
+		{"vendor header", withHeader, "[2001:db8::1]:443", map[string]string{"CF-Connecting-IP": "198.51.100.4", "X-Forwarded-For": "192.0.2.1"}, "198.51.100.4"},
+		{"vendor header from untrusted remote", withHeader, "203.0.113.1:1234", map[string]string{"CF-Connecting-IP": "198.51.100.4"}, "203.0.113.1"},
+		{"ipv4 mapped remote", withoutTrusted, "[::ffff:203.0.113.9]:1234", nil, "203.0.113.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			if got := tt.resolver.ClientIP(r); got != tt.expected {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
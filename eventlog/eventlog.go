@@ -0,0 +1,98 @@
+package eventlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger writes one line per event in a stable format meant for fail2ban or CrowdSec:
+//
+//	2024-05-01T10:00:00.000Z event=status ip=192.0.2.1 reason=status:404 path="/missing"
+//	2024-05-01T10:00:00.000Z event=rule ip=192.0.2.1 reason=rule:php action=ban path="/index.php"
+//	2024-05-01T10:00:00.000Z event=ban ip=192.0.2.1 reason=threshold kind=ip until=2024-05-01T11:00:00Z
+//
+// The time, event, ip and reason always come first in that order, the other fields may grow.
+// A nil Logger discards the events.
+type Logger struct {
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+}
+
+// New writes the events to out
+func New(out io.Writer) *Logger {
+	return &Logger{out: out, now: time.Now}
+}
+
+// Open appends the events to the file, "-" writes them to stdout
+func Open(path string) (*Logger, error) {
+	if path == "-" {
+		return New(os.Stdout), nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return New(file), nil
+}
+
+// Status records a response with a status counting against the ip (404 by default)
+func (l *Logger) Status(ip string, status int, path string) {
+	l.write("status", ip, "status:"+strconv.Itoa(status), "path", path)
+}
+
+// Rule records a rule matching a request of the ip
+func (l *Logger) Rule(ip string, rule string, action string, path string) {
+	l.write("rule", ip, "rule:"+rule, "action", action, "path", path)
+}
+
+// Shadow records what a rule in shadow mode would have done, the shipped filters ignore these lines
+func (l *Logger) Shadow(ip string, reason string, action string, path string) {
+	l.write("shadow", ip, reason, "action", action, "path", path)
+}
+
+// Ban records a ban of an ip, a subnet or a range
+func (l *Logger) Ban(target string, kind string, reason string, until time.Time) {
+	l.write("ban", target, reason, "kind", kind, "until", until.UTC().Format(time.RFC3339))
+}
+
+// Unban records a ban lifted before its expiry
+func (l *Logger) Unban(target string, kind string) {
+	l.write("unban", target, "unban", "kind", kind)
+}
+
+// write formats the line, fields are key value pairs
+func (l *Logger) write(event string, ip string, reason string, fields ...string) {
+	if l == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(l.now().UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	fmt.Fprintf(&b, " event=%s ip=%s reason=%s", event, quote(ip), quote(reason))
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := quote(fields[i+1])
+		if fields[i] == "path" {
+			// paths come from the clients, always quote them so the filters can rely on it
+			value = strconv.Quote(fields[i+1])
+		}
+		fmt.Fprintf(&b, " %s=%s", fields[i], value)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, b.String())
+}
+
+// quote keeps the values on a single space separated line
+func quote(value string) string {
+	if value == "" || strings.ContainsFunc(value, func(r rune) bool { return r <= ' ' || r >= 0x7f || r == '"' || r == '\\' }) {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"reverseproxy/eventlog"
+	"reverseproxy/trackers/ip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failRegex mirrors contrib/fail2ban/filter.d/banme.conf in aggressive mode
+var failRegex = regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z event=(?:ban|status|rule) ip=(\S+) reason=\S+`)
+
+func TestEventLog(t *testing.T) {
+	var out bytes.Buffer
+	events := eventlog.New(&out)
+	until := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+
+	events.Status("192.0.2.1", 404, "/missing page")
+	events.Rule("192.0.2.1", "php", "ban", `/index.php?a="b"`)
+	events.Ban("192.0.2.0/24", "subnet", "subnet", until)
+	events.Unban("192.0.2.0/24", "subnet")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{
+		`event=status ip=192.0.2.1 reason=status:404 path="/missing page"`,
+		`event=rule ip=192.0.2.1 reason=rule:php action=ban path="/index.php?a=\"b\""`,
+		`event=ban ip=192.0.2.0/24 reason=subnet kind=subnet until=2024-05-01T11:00:00Z`,
+		`event=unban ip=192.0.2.0/24 reason=unban kind=subnet`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %q, want %d lines", lines, len(want))
+	}
+	for i, line := range lines {
+		if _, fields, _ := strings.Cut(line, " "); fields != want[i] {
+			t.Errorf("line %d = %q, want %q", i, fields, want[i])
+		}
+	}
+	for i, ip := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.0/24", ""} {
+		match := failRegex.FindStringSubmatch(lines[i])
+		if ip == "" && match != nil {
+			t.Errorf("filter should ignore %q", lines[i])
+		} else if ip != "" && (match == nil || match[1] != ip) {
+			t.Errorf("filter match of %q = %q, want ip %s", lines[i], match, ip)
+		}
+	}
+
+	var discarded *eventlog.Logger
+	discarded.Ban("192.0.2.1", "ip", "threshold", until)
+}
+
+// syncBuffer is read by the test while the tracker listener writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestEventLogBans(t *testing.T) {
+	out := &syncBuffer{}
+	tracker := ip.NewIPTracker(1, time.Minute, time.Hour)
+	tracker.OnBan(logBan(eventlog.New(out)))
+	tracker.Ban("192.0.2.9", ip.ReasonHoneypot)
+
+	deadline := time.Now().Add(time.Second)
+	for out.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(out.String(), "event=ban ip=192.0.2.9 reason=honeypot kind=ip") {
+		t.Errorf("event log = %q", out.String())
+	}
+}
@@ -47,6 +47,7 @@ func runCmd(ctx context.Context, cancel context.CancelFunc, cmd string, args ...
 
 	err = process.Wait()
 	cancel()
+	runShutdownHooks()
 
 	if err == nil {
 		log.Println("Command finished successfully.")
@@ -0,0 +1,78 @@
+package firewall
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Backend mirrors the bans in the kernel firewall so banned ips are dropped before reaching the proxy
+type Backend interface {
+	// Setup creates the sets, it's safe to call when they already exist
+	Setup() error
+	// Ban adds the ip or the prefix to the set, the kernel expires it after the duration
+	Ban(target string, duration time.Duration) error
+	// Unban removes the ip or the prefix from the set
+	Unban(target string) error
+}
+
+// Runner executes a firewall command
+type Runner func(name string, args ...string) error
+
+// ExecRunner runs the command, reporting its output on failure
+func ExecRunner(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// DryRunRunner only logs the command, to try the firewall integration without root
+func DryRunRunner(name string, args ...string) error {
+	log.Printf("Firewall dry-run: %s %s", name, strings.Join(args, " "))
+	return nil
+}
+
+// New returns the backend by name ("nftables" or "ipset") managing the sets <set>4 and <set>6
+func New(kind string, set string, dryRun bool) (Backend, error) {
+	run := ExecRunner
+	if dryRun {
+		run = DryRunRunner
+	}
+	switch kind {
+	case "nftables", "nft":
+		return &Nftables{Table: set, Set: set, Run: run}, nil
+	case "ipset":
+		return &Ipset{Set: set, Run: run}, nil
+	}
+	return nil, fmt.Errorf("unknown firewall backend %q, expected nftables or ipset", kind)
+}
+
+// family returns the suffix of the set holding the target: "4" or "6"
+func family(target string) (string, error) {
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return "", fmt.Errorf("not an ip nor a prefix: %q", target)
+		}
+		addr = prefix.Addr()
+	}
+	if addr.Unmap().Is4() {
+		return "4", nil
+	}
+	return "6", nil
+}
+
+// seconds rounds the duration up, the firewalls don't support sub second timeouts
+func seconds(duration time.Duration) int64 {
+	s := int64((duration + time.Second - 1) / time.Second)
+	if s < 1 {
+		return 1
+	}
+	return s
+}
@@ -0,0 +1,47 @@
+package firewall
+
+import (
+	"strconv"
+	"time"
+)
+
+// maxIpsetTimeout is the largest timeout accepted by ipset, in seconds
+const maxIpsetTimeout = 2147483
+
+// Ipset manages the hash:net sets <Set>4 and <Set>6.
+//
+// The sets only hold the bans, the rule dropping their traffic is left to the admin, ex:
+//
+//	iptables -I INPUT -m set --match-set banme4 src -j DROP
+//	ip6tables -I INPUT -m set --match-set banme6 src -j DROP
+type Ipset struct {
+	Set string
+	Run Runner
+}
+
+// Setup creates the sets, "-exist" makes it a no-op when they exist
+func (i *Ipset) Setup() error {
+	for family, name := range map[string]string{"4": "inet", "6": "inet6"} {
+		if err := i.Run("ipset", "create", i.Set+family, "hash:net", "family", name, "timeout", "0", "-exist"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Ipset) Ban(target string, duration time.Duration) error {
+	family, err := family(target)
+	if err != nil {
+		return err
+	}
+	timeout := min(seconds(duration), maxIpsetTimeout)
+	return i.Run("ipset", "add", i.Set+family, target, "timeout", strconv.FormatInt(timeout, 10), "-exist")
+}
+
+func (i *Ipset) Unban(target string) error {
+	family, err := family(target)
+	if err != nil {
+		return err
+	}
+	return i.Run("ipset", "del", i.Set+family, target, "-exist")
+}
@@ -0,0 +1,195 @@
+package firewall
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Nftables manages the sets <Set>4 and <Set>6 in the inet table Table.
+//
+// The sets only hold the bans, the rule dropping their traffic is left to the admin, ex:
+//
+//	nft add chain inet banme input '{ type filter hook input priority -10; }'
+//	nft add rule inet banme input ip saddr @banme4 drop
+//	nft add rule inet banme input ip6 saddr @banme6 drop
+//
+// The elements of an interval set can't overlap: a ban covered by a wider one (an ip of a banned
+// subnet) is kept out of the set and added back when the wider ban is lifted or expires.
+type Nftables struct {
+	Table string
+	Set   string
+	Run   Runner
+
+	mu sync.Mutex
+	// bans are all the mirrored bans with their expiry, installed the ones in the kernel sets
+	bans      map[netip.Prefix]time.Time
+	installed map[netip.Prefix]bool
+}
+
+// Setup creates the table and the sets, nft "add" is a no-op when they exist. The sets are
+// flushed so they only hold the bans mirrored from now on.
+func (n *Nftables) Setup() error {
+	if err := n.Run("nft", "add", "table", "inet", n.Table); err != nil {
+		return err
+	}
+	for _, family := range []string{"4", "6"} {
+		spec := fmt.Sprintf("{ type ipv%s_addr; flags interval, timeout; }", family)
+		if err := n.Run("nft", "add", "set", "inet", n.Table, n.Set+family, spec); err != nil {
+			return err
+		}
+		if err := n.Run("nft", "flush", "set", "inet", n.Table, n.Set+family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Nftables) Ban(target string, duration time.Duration) error {
+	prefix, err := prefixOf(target)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	n.expire(now)
+	n.bans[prefix] = now.Add(time.Duration(seconds(duration)) * time.Second)
+	return n.add(prefix, now)
+}
+
+func (n *Nftables) Unban(target string) error {
+	prefix, err := prefixOf(target)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	n.expire(now)
+	delete(n.bans, prefix)
+	if !n.installed[prefix] {
+		return nil
+	}
+	if err := n.delete(prefix); err != nil {
+		return err
+	}
+	// the bans it covered are back in the set, the widest first
+	var covered []netip.Prefix
+	for other := range n.bans {
+		if prefix.Overlaps(other) {
+			covered = append(covered, other)
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool {
+		if covered[i].Bits() != covered[j].Bits() {
+			return covered[i].Bits() < covered[j].Bits()
+		}
+		return covered[i].Addr().Less(covered[j].Addr())
+	})
+	for _, other := range covered {
+		if !n.installed[other] {
+			if err := n.add(other, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// add puts the ban in the set unless a wider ban covers it, the narrower bans it covers are
+// taken out. An element already in the set is deleted first to refresh its timeout, caller
+// must hold the lock.
+func (n *Nftables) add(prefix netip.Prefix, now time.Time) error {
+	until := n.bans[prefix]
+	for other := range n.installed {
+		if other == prefix || !other.Overlaps(prefix) {
+			continue
+		}
+		if other.Bits() <= prefix.Bits() {
+			if until.After(n.bans[other]) {
+				n.restoreAt(prefix, n.bans[other])
+			}
+			return nil
+		}
+		if err := n.delete(other); err != nil {
+			return err
+		}
+		if n.bans[other].After(until) {
+			n.restoreAt(other, until)
+		}
+	}
+	if n.installed[prefix] {
+		// it may already be expired in the kernel, the add below is what matters
+		n.delete(prefix)
+	}
+	family, _ := family(prefix.String())
+	element := fmt.Sprintf("{ %s timeout %ds }", target(prefix), seconds(until.Sub(now)))
+	if err := n.Run("nft", "add", "element", "inet", n.Table, n.Set+family, element); err != nil {
+		return err
+	}
+	n.installed[prefix] = true
+	return nil
+}
+
+// delete removes the element from the set, caller must hold the lock
+func (n *Nftables) delete(prefix netip.Prefix) error {
+	delete(n.installed, prefix)
+	family, _ := family(prefix.String())
+	element := fmt.Sprintf("{ %s }", target(prefix))
+	return n.Run("nft", "delete", "element", "inet", n.Table, n.Set+family, element)
+}
+
+// restoreAt adds the ban back once the wider ban covering it expired in the kernel
+func (n *Nftables) restoreAt(prefix netip.Prefix, coverUntil time.Time) {
+	time.AfterFunc(time.Until(coverUntil)+time.Second, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		now := time.Now()
+		n.expire(now)
+		if _, banned := n.bans[prefix]; !banned || n.installed[prefix] {
+			return
+		}
+		if err := n.add(prefix, now); err != nil {
+			log.Printf("Failed to restore the ban of %s in the firewall: %v", target(prefix), err)
+		}
+	})
+}
+
+// expire forgets the bans the kernel expired, caller must hold the lock
+func (n *Nftables) expire(now time.Time) {
+	if n.bans == nil {
+		n.bans = make(map[netip.Prefix]time.Time)
+		n.installed = make(map[netip.Prefix]bool)
+	}
+	for prefix, until := range n.bans {
+		if !now.Before(until) {
+			delete(n.bans, prefix)
+			delete(n.installed, prefix)
+		}
+	}
+}
+
+// prefixOf parses an ip as a single address prefix or a prefix
+func prefixOf(value string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("not an ip nor a prefix: %q", value)
+	}
+	return prefix.Masked(), nil
+}
+
+// target formats the prefix like the bans, single addresses without their length
+func target(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
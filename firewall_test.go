@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reverseproxy/firewall"
+	"reverseproxy/trackers/ip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects the firewall commands instead of running them
+type recorder struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *recorder) run(name string, args ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, name+" "+strings.Join(args, " "))
+	return nil
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+func TestFirewallCommands(t *testing.T) {
+	commands := &recorder{}
+	nft := &firewall.Nftables{Table: "banme", Set: "banme", Run: commands.run}
+	ipset := &firewall.Ipset{Set: "banme", Run: commands.run}
+
+	nft.Ban("192.0.2.1", 90*time.Second)
+	nft.Ban("2001:db8::/64", time.Hour)
+	nft.Unban("192.0.2.1")
+	ipset.Ban("198.51.100.0/24", 1500*time.Millisecond)
+	ipset.Unban("2001:db8::1")
+
+	want := []string{
+		"nft add element inet banme banme4 { 192.0.2.1 timeout 90s }",
+		"nft add element inet banme banme6 { 2001:db8::/64 timeout 3600s }",
+		"nft delete element inet banme banme4 { 192.0.2.1 }",
+		"ipset add banme4 198.51.100.0/24 timeout 2 -exist",
+		"ipset del banme6 2001:db8::1 -exist",
+	}
+	got := commands.get()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+
+	if err := nft.Ban("not an ip", time.Minute); err == nil {
+		t.Errorf("Ban() of an invalid target should fail")
+	}
+	if _, err := firewall.New("pf", "banme", true); err == nil {
+		t.Errorf("New() of an unknown backend should fail")
+	}
+}
+
+func TestNftablesOverlaps(t *testing.T) {
+	commands := &recorder{}
+	nft := &firewall.Nftables{Table: "banme", Set: "banme", Run: commands.run}
+
+	nft.Setup()
+	nft.Ban("192.0.2.1", time.Hour)
+	nft.Ban("192.0.2.1", 2*time.Hour)
+	nft.Ban("192.0.2.0/24", 10*time.Minute)
+	nft.Ban("192.0.2.7", time.Minute)
+	nft.Unban("192.0.2.0/24")
+
+	want := []string{
+		"nft add table inet banme",
+		"nft add set inet banme banme4 { type ipv4_addr; flags interval, timeout; }",
+		"nft flush set inet banme banme4",
+		"nft add set inet banme banme6 { type ipv6_addr; flags interval, timeout; }",
+		"nft flush set inet banme banme6",
+		"nft add element inet banme banme4 { 192.0.2.1 timeout 3600s }",
+		// the ban is extended
+		"nft delete element inet banme banme4 { 192.0.2.1 }",
+		"nft add element inet banme banme4 { 192.0.2.1 timeout 7200s }",
+		// the subnet replaces the ip, the ip banned meanwhile is already covered
+		"nft delete element inet banme banme4 { 192.0.2.1 }",
+		"nft add element inet banme banme4 { 192.0.2.0/24 timeout 600s }",
+		// the ips are back once the subnet is unbanned
+		"nft delete element inet banme banme4 { 192.0.2.0/24 }",
+		"nft add element inet banme banme4 { 192.0.2.1 timeout 7200s }",
+		"nft add element inet banme banme4 { 192.0.2.7 timeout 60s }",
+	}
+	got := commands.get()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestFirewallMirrorsBans(t *testing.T) {
+	commands := &recorder{}
+	tracker := ip.NewIPTracker(1, time.Minute, time.Hour)
+	tracker.OnBan(mirrorBan(&firewall.Ipset{Set: "banme", Run: commands.run}))
+
+	tracker.IncrementHit("192.0.2.1")
+	tracker.IncrementHit("192.0.2.1")
+	tracker.Unban("192.0.2.1")
+
+	deadline := time.Now().Add(time.Second)
+	for len(commands.get()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	want := []string{
+		"ipset add banme4 192.0.2.1 timeout 3600 -exist",
+		"ipset del banme4 192.0.2.1 -exist",
+	}
+	got := commands.get()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
@@ -11,3 +11,5 @@ require github.com/google/uuid v1.6.0
 require github.com/ccojocar/randdetect v0.0.0-20241118085251-1581dcdbf207
 
 require github.com/lib/pq v1.10.9
+
+require github.com/oschwald/maxminddb-golang v1.13.1
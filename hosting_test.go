@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reverseproxy/ipinfo"
+	"reverseproxy/rules"
+	"testing"
+)
+
+func TestHostingProvider(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"aws.json":          `{"prefixes": [{"ip_prefix": "3.5.140.0/22", "service": "AMAZON"}], "ipv6_prefixes": [{"ipv6_prefix": "2600:1f00::/24"}]}`,
+		"gcp.json":          `{"prefixes": [{"ipv4Prefix": "34.1.208.0/20"}, {"ipv6Prefix": "2600:1900::/28"}]}`,
+		"digitalocean.txt":  "# comment\n5.101.96.0/21\n\n",
+		"digitalocean2.csv": "ignored, not a .json or .txt",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hosting, err := ipinfo.NewHosting([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"3.5.141.10", "aws"},
+		{"2600:1f00::1", "aws"},
+		{"34.1.210.1", "gcp"},
+		{"2600:1900::1", "gcp"},
+		{"5.101.100.1", "digitalocean"},
+		{"192.0.2.1", ""},
+		{"not an ip", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := hosting.Provider(tt.ip); got != tt.expected {
+				t.Errorf("Provider(%q) = %q, want %q", tt.ip, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHostingRules(t *testing.T) {
+	engine, err := rules.NewEngine([]rules.Rule{
+		{Name: "datacenter-admin", Glob: "/admin/**", Action: rules.ActionBan, Hosting: true},
+		{Name: "aws-login", Glob: "/login", Action: rules.ActionScore, Weight: 5, Providers: []string{"aws"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		request  rules.Request
+		expected int
+	}{
+		{rules.Request{Path: "/admin/users"}, 0},
+		{rules.Request{Path: "/admin/users", Provider: "gcp"}, 1},
+		{rules.Request{Path: "/login", Provider: "gcp"}, 0},
+		{rules.Request{Path: "/login", Provider: "aws"}, 1},
+	}
+	for _, tt := range tests {
+		if got := len(engine.Match(tt.request)); got != tt.expected {
+			t.Errorf("Match(%+v) matched %d rules, want %d", tt.request, got, tt.expected)
+		}
+	}
+}
+
+func TestGeoRules(t *testing.T) {
+	engine, err := rules.NewEngine([]rules.Rule{
+		{Name: "asn-404", Action: rules.ActionBan, ASNs: []uint{64496}, Statuses: []int{404}},
+		{Name: "country-admin", Glob: "/admin/**", Action: rules.ActionBlock, Countries: []string{"zz"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		request  rules.Request
+		expected string
+	}{
+		{rules.Request{Path: "/anything", ASN: 64496}, ""},
+		{rules.Request{Path: "/anything", ASN: 64496, Status: 404}, "asn-404"},
+		{rules.Request{Path: "/anything", ASN: 64497, Status: 404}, ""},
+		{rules.Request{Path: "/admin/users", Country: "ZZ"}, "country-admin"},
+		{rules.Request{Path: "/admin/users", Country: "ZZ", Status: 200}, ""},
+		{rules.Request{Path: "/admin/users", Country: "BE"}, ""},
+	}
+	for _, tt := range tests {
+		matched := engine.Match(tt.request)
+		got := ""
+		if len(matched) > 0 {
+			got = matched[0].Name
+		}
+		if got != tt.expected {
+			t.Errorf("Match(%+v) = %q, want %q", tt.request, got, tt.expected)
+		}
+	}
+
+	if _, err := rules.NewEngine([]rules.Rule{{Name: "everything", Action: rules.ActionBan}}); err == nil {
+		t.Errorf("a rule without any condition should be refused")
+	}
+	if _, err := rules.NewEngine([]rules.Rule{{Name: "block-404", Action: rules.ActionBlock, Statuses: []int{404}}}); err == nil {
+		t.Errorf("a block rule on a response status should be refused")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reverseproxy/ipranges"
+	"reverseproxy/trackers/ip"
+	"testing"
+	"time"
+)
+
+func TestIPTrackerSlidingWindow(t *testing.T) {
+	tracker := ip.NewIPTracker(3, 50*time.Millisecond, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		tracker.IncrementHit("10.0.0.1")
+	}
+	if hits := tracker.GetHits("10.0.0.1"); hits != 3 {
+		t.Errorf("GetHits() = %d, want 3", hits)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if hits := tracker.GetHits("10.0.0.1"); hits != 0 {
+		t.Errorf("GetHits() after window = %d, want 0", hits)
+	}
+
+	// the old hits decayed so this one should not trigger a ban
+	tracker.IncrementHit("10.0.0.1")
+	if tracker.CheckBan("10.0.0.1") {
+		t.Errorf("CheckBan() = true, want false once the previous hits left the window")
+	}
+
+	for i := 0; i < 3; i++ {
+		tracker.IncrementHit("10.0.0.1")
+	}
+	if !tracker.CheckBan("10.0.0.1") {
+		t.Errorf("CheckBan() = false, want true after exceeding the threshold within the window")
+	}
+}
+
+func TestIPTrackerBanEscalation(t *testing.T) {
+	tracker := ip.NewIPTracker(0, time.Minute, time.Minute)
+	tracker.SetBanEscalation([]time.Duration{time.Minute, 10 * time.Minute, time.Hour}, 30*time.Minute, time.Hour)
+
+	expected := []time.Duration{time.Minute, 10 * time.Minute, 30 * time.Minute, 30 * time.Minute}
+	for i, want := range expected {
+		tracker.UnbanAll()
+		tracker.IncrementHit("10.0.0.2")
+
+		banned := tracker.GetTrackerInfo()["banned"].(map[string]ip.Ban)
+		ban, ok := banned["10.0.0.2"]
+		if !ok {
+			t.Fatalf("offense %d: ip not banned", i+1)
+		}
+		if ban.Offenses != i+1 {
+			t.Errorf("offense %d: Offenses = %d", i+1, ban.Offenses)
+		}
+		if got := ban.Until.Sub(ban.Since); got != want {
+			t.Errorf("offense %d: ban duration = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestIPTrackerAccessLists(t *testing.T) {
+	allow, err := ipranges.ParseList("10.1.0.0/16, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, err := ipranges.ParseList("192.0.2.0/24,10.1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := ip.NewIPTracker(0, time.Minute, time.Minute)
+	tracker.SetAccessLists(allow, deny)
+
+	tests := []struct {
+		ip     string
+		banned bool
+	}{
+		{"10.1.2.3", false},         // allowed wins over denied
+		{"2001:db8::1", false},      // allowed ipv6
+		{"192.0.2.10", true},        // denied
+		{"::ffff:192.0.2.10", true}, // denied as ipv4 mapped ipv6
+		{"203.0.113.5", true},       // banned by the 404 threshold
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			tracker.IncrementHit(tt.ip)
+			if got := tracker.CheckBan(tt.ip); got != tt.banned {
+				t.Errorf("CheckBan(%q) = %v, want %v", tt.ip, got, tt.banned)
+			}
+		})
+	}
+}
+
+func TestIPTrackerWeightedScore(t *testing.T) {
+	tracker := ip.NewIPTracker(5, time.Minute, time.Minute)
+
+	tracker.AddScore("10.0.0.3", 3)
+	tracker.AddScore("10.0.0.3", 0.5)
+	if score := tracker.GetScore("10.0.0.3"); score != 3.5 {
+		t.Errorf("GetScore() = %v, want 3.5", score)
+	}
+	if tracker.CheckBan("10.0.0.3") {
+		t.Errorf("CheckBan() = true, want false below the threshold")
+	}
+
+	tracker.AddScore("10.0.0.3", 3)
+	if !tracker.CheckBan("10.0.0.3") {
+		t.Errorf("CheckBan() = false, want true once the score exceeds the threshold")
+	}
+}
+
+func TestIPTrackerSubnetBan(t *testing.T) {
+	tracker := ip.NewIPTracker(10, time.Minute, time.Minute)
+	tracker.SetSubnetAggregation(24, 64, 5)
+
+	// each ip stays well under its own threshold
+	for i := 1; i <= 6; i++ {
+		tracker.IncrementHit(fmt.Sprintf("198.51.100.%d", i))
+	}
+	if !tracker.CheckBan("198.51.100.200") {
+		t.Errorf("CheckBan() = false, want true for an ip of the banned subnet")
+	}
+	if tracker.CheckBan("198.51.101.1") {
+		t.Errorf("CheckBan() = true, want false for an ip of another subnet")
+	}
+
+	bannedSubnets := tracker.GetTrackerInfo()["bannedSubnets"].(map[string]ip.Ban)
+	if _, ok := bannedSubnets["198.51.100.0/24"]; !ok {
+		t.Errorf("bannedSubnets = %v, want 198.51.100.0/24", bannedSubnets)
+	}
+}
+
+func TestIPTrackerStatePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	tracker := ip.NewIPTracker(2, time.Minute, time.Hour)
+	tracker.SetBanEscalation([]time.Duration{50 * time.Millisecond, time.Hour}, 0, time.Hour)
+	tracker.Ban("10.0.0.4", "rule:test") // short first ban, expired once reloaded
+	tracker.Ban("10.0.0.5", "rule:test")
+	tracker.Ban("10.0.0.5", "rule:test") // second offense, one hour ban
+	tracker.IncrementHit("10.0.0.6")
+	tracker.IncrementStatus("10.0.0.6", 404)
+	if err := tracker.SaveState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	restored := ip.NewIPTracker(2, time.Minute, time.Hour)
+	restored.SetBanEscalation([]time.Duration{50 * time.Millisecond, time.Hour}, 0, time.Hour)
+	if err := restored.LoadState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.CheckBan("10.0.0.4") {
+		t.Errorf("expired ban of 10.0.0.4 should be dropped while loading")
+	}
+	if !restored.CheckBan("10.0.0.5") {
+		t.Errorf("ban of 10.0.0.5 should survive the restart")
+	}
+	if hits := restored.GetHits("10.0.0.6"); hits != 1 {
+		t.Errorf("GetHits(10.0.0.6) = %d, want 1", hits)
+	}
+	offenses := restored.GetTrackerInfo()["offenses"].(map[string]ip.Offense)
+	if offenses["10.0.0.4"].Count != 1 {
+		t.Errorf("offense history of 10.0.0.4 should survive its expired ban, got %+v", offenses["10.0.0.4"])
+	}
+
+	if err := ip.NewIPTracker(2, time.Minute, time.Hour).LoadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("LoadState() of a missing file = %v, want nil", err)
+	}
+}
+
+func TestIPTrackerPrune(t *testing.T) {
+	tracker := ip.NewIPTracker(10, time.Minute, time.Hour)
+
+	tracker.IncrementStatus("10.0.1.1", 200)
+	tracker.IncrementHit("10.0.1.1")
+	tracker.Ban("10.0.1.1", "rule:test")
+	time.Sleep(30 * time.Millisecond)
+	for i := 2; i <= 5; i++ {
+		tracker.IncrementStatus(fmt.Sprintf("10.0.1.%d", i), 200)
+		time.Sleep(time.Millisecond)
+	}
+
+	pruned, evicted := tracker.Prune(20*time.Millisecond, 3)
+	if pruned != 1 || evicted != 1 {
+		t.Errorf("Prune() = %d pruned, %d evicted, want 1 and 1", pruned, evicted)
+	}
+
+	info := tracker.GetTrackerInfo()
+	statusCountPerIp := info["statusCountPerIp"].(map[string]map[int]int)
+	for _, expected := range []string{"10.0.1.3", "10.0.1.4", "10.0.1.5"} {
+		if _, ok := statusCountPerIp[expected]; !ok {
+			t.Errorf("%s should still be tracked, got %v", expected, statusCountPerIp)
+		}
+	}
+	if len(statusCountPerIp) != 3 {
+		t.Errorf("tracked ips = %v, want 3", statusCountPerIp)
+	}
+	if !tracker.CheckBan("10.0.1.1") {
+		t.Errorf("pruning the counters of an ip should keep its active ban")
+	}
+	if info["system.prunedIps"].(int64) != 1 || info["system.evictedIps"].(int64) != 1 {
+		t.Errorf("cleanup counters = %v pruned, %v evicted", info["system.prunedIps"], info["system.evictedIps"])
+	}
+}
@@ -0,0 +1,115 @@
+package ipinfo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"reverseproxy/ipranges"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Geo is the country and autonomous system of an ip
+type Geo struct {
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+}
+
+// countryRecord is the part of a GeoLite2-Country record we use
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// asnRecord is a GeoLite2-ASN record
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoIP looks up ips in local MaxMind format databases (GeoLite2 Country and ASN),
+// both databases are optional
+type GeoIP struct {
+	countryPath string
+	asnPath     string
+	country     atomic.Pointer[maxminddb.Reader]
+	asn         atomic.Pointer[maxminddb.Reader]
+}
+
+// NewGeoIP opens the databases, an empty path skips that database
+func NewGeoIP(countryPath string, asnPath string) (*GeoIP, error) {
+	geoIP := &GeoIP{countryPath: countryPath, asnPath: asnPath}
+	if err := geoIP.open(countryPath, &geoIP.country); err != nil {
+		return nil, err
+	}
+	if err := geoIP.open(asnPath, &geoIP.asn); err != nil {
+		return nil, err
+	}
+	return geoIP, nil
+}
+
+// open loads the database in memory and swaps it with the previous one
+func (g *GeoIP) open(path string, reader *atomic.Pointer[maxminddb.Reader]) error {
+	if path == "" {
+		return nil
+	}
+	// FromBytes instead of Open (mmap) so the file can be overwritten while we use it
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	db, err := maxminddb.FromBytes(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	reader.Store(db)
+	return nil
+}
+
+// Lookup returns what the databases know about the ip
+func (g *GeoIP) Lookup(ip string) Geo {
+	var geo Geo
+	if g == nil {
+		return geo
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return geo
+	}
+	if db := g.country.Load(); db != nil {
+		var record countryRecord
+		if err := db.Lookup(addr, &record); err == nil {
+			geo.Country = record.Country.ISOCode
+		}
+	}
+	if db := g.asn.Load(); db != nil {
+		var record asnRecord
+		if err := db.Lookup(addr, &record); err == nil {
+			geo.ASN = record.Number
+			geo.Org = record.Organization
+		}
+	}
+	return geo
+}
+
+// Watch reopens a database when its file changes, until ctx is done
+func (g *GeoIP) Watch(ctx context.Context, interval time.Duration) {
+	for path, reader := range map[string]*atomic.Pointer[maxminddb.Reader]{g.countryPath: &g.country, g.asnPath: &g.asn} {
+		if path == "" {
+			continue
+		}
+		go ipranges.WatchFile(ctx, path, interval, func() {
+			if err := g.open(path, reader); err != nil {
+				log.Printf("Failed to reload %s, keeping the previous database: %v", path, err)
+				return
+			}
+			log.Printf("Reloaded %s", path)
+		})
+	}
+}
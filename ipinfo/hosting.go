@@ -0,0 +1,147 @@
+package ipinfo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reverseproxy/ipranges"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Hosting classifies ips by hosting/cloud provider based on local range files:
+//   - AWS ip-ranges.json (https://ip-ranges.amazonaws.com/ip-ranges.json)
+//   - GCP cloud.json (https://www.gstatic.com/ipranges/cloud.json)
+//   - text files with one range per line (https://github.com/femueller/cloud-ip-ranges)
+//
+// The provider of a range is the name of its file without extension (aws.json -> aws).
+type Hosting struct {
+	paths []string
+	table atomic.Pointer[ipranges.Table]
+}
+
+// rangesFile covers both the AWS and GCP json formats
+type rangesFile struct {
+	Prefixes []struct {
+		IPPrefix   string `json:"ip_prefix"`
+		IPv4Prefix string `json:"ipv4Prefix"`
+		IPv6Prefix string `json:"ipv6Prefix"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		IPv6Prefix string `json:"ipv6_prefix"`
+	} `json:"ipv6_prefixes"`
+}
+
+// NewHosting loads the files, directories are expanded to their .json and .txt files
+func NewHosting(paths []string) (*Hosting, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		for _, pattern := range []string{"*.json", "*.txt"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			files = append(files, matches...)
+		}
+	}
+	hosting := &Hosting{paths: files}
+	if err := hosting.Reload(); err != nil {
+		return nil, err
+	}
+	return hosting, nil
+}
+
+// Reload rebuilds the lookup table from the files, the previous table is kept on error
+func (h *Hosting) Reload() error {
+	table := ipranges.NewTable()
+	for _, path := range h.paths {
+		prefixes, err := loadRanges(path)
+		if err != nil {
+			return err
+		}
+		provider := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		for _, prefix := range prefixes {
+			table.Insert(prefix, provider)
+		}
+	}
+	h.table.Store(table)
+	return nil
+}
+
+// loadRanges reads the ranges of a json (AWS/GCP) or text file
+func loadRanges(path string) ([]netip.Prefix, error) {
+	if filepath.Ext(path) != ".json" {
+		list, err := ipranges.LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return list.Prefixes(), nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file rangesFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var values []string
+	for _, prefix := range file.Prefixes {
+		values = append(values, prefix.IPPrefix, prefix.IPv4Prefix, prefix.IPv6Prefix)
+	}
+	for _, prefix := range file.IPv6Prefixes {
+		values = append(values, prefix.IPv6Prefix)
+	}
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		prefix, err := ipranges.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Provider returns the hosting provider of the ip, "" if it is not a known datacenter address
+func (h *Hosting) Provider(ip string) string {
+	if h == nil {
+		return ""
+	}
+	provider, _ := h.table.Load().Lookup(ip)
+	return provider
+}
+
+// Len returns the number of loaded ranges
+func (h *Hosting) Len() int {
+	if h == nil {
+		return 0
+	}
+	return h.table.Load().Len()
+}
+
+// Watch reloads all the files as soon as one of them changes, until ctx is done
+func (h *Hosting) Watch(ctx context.Context, interval time.Duration) {
+	for _, path := range h.paths {
+		go ipranges.WatchFile(ctx, path, interval, func() {
+			if err := h.Reload(); err != nil {
+				log.Printf("Failed to reload hosting ranges, keeping the previous ones: %v", err)
+				return
+			}
+			log.Printf("Reloaded hosting ranges after a change of %s, %d ranges", path, h.Len())
+		})
+	}
+}
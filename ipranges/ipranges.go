@@ -0,0 +1,190 @@
+package ipranges
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// List is a set of ipv4 and ipv6 ranges, safe for concurrent use
+type List struct {
+	mu       sync.RWMutex
+	prefixes []netip.Prefix
+}
+
+// NewList creates a list holding the given ranges
+func NewList(prefixes []netip.Prefix) *List {
+	return &List{prefixes: prefixes}
+}
+
+// ParsePrefix parses a CIDR like 10.0.0.0/8 or a single ip (turned into a /32 or /128)
+func ParsePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Parse reads one range per line, blank lines and # comments are ignored
+func Parse(reader io.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		prefix, err := ParsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, scanner.Err()
+}
+
+// ParseList parses a comma separated list of ranges
+func ParseList(value string) (*List, error) {
+	prefixes, err := Parse(strings.NewReader(strings.ReplaceAll(value, ",", "\n")))
+	if err != nil {
+		return nil, err
+	}
+	return NewList(prefixes), nil
+}
+
+// LoadFile reads the ranges of a file, see Parse for the format
+func LoadFile(path string) (*List, error) {
+	list := NewList(nil)
+	if err := list.Load(path); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Load replaces the ranges of the list with the ones of the file
+func (l *List) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	prefixes, err := Parse(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	l.Set(prefixes)
+	return nil
+}
+
+// Set replaces the ranges of the list
+func (l *List) Set(prefixes []netip.Prefix) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prefixes = prefixes
+}
+
+// Prefixes returns the ranges of the list
+func (l *List) Prefixes() []netip.Prefix {
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.prefixes
+}
+
+// Len returns the number of ranges in the list
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.prefixes)
+}
+
+// Contains reports if the ip is part of one of the ranges, a nil list contains nothing
+func (l *List) Contains(ip string) bool {
+	if l == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return l.ContainsAddr(addr)
+}
+
+// ContainsAddr is Contains for an already parsed address
+func (l *List) ContainsAddr(addr netip.Addr) bool {
+	if l == nil {
+		return false
+	}
+	addr = addr.Unmap()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the list every time the modification time of the file changes, until ctx is done
+func (l *List) Watch(ctx context.Context, path string, interval time.Duration) {
+	WatchFile(ctx, path, interval, func() {
+		if err := l.Load(path); err != nil {
+			log.Printf("Failed to reload %s, keeping the previous ranges: %v", path, err)
+			return
+		}
+		log.Printf("Reloaded %s with %d ranges", path, l.Len())
+	})
+}
+
+// WatchFile polls the modification time of the file and calls reload when it changes
+func WatchFile(ctx context.Context, path string, interval time.Duration, reload func()) {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
+			reload()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package ipranges
+
+import (
+	"net/netip"
+	"sync"
+)
+
+// node of a binary trie, one level per bit of the address
+type node struct {
+	children [2]*node
+	label    string
+	set      bool
+}
+
+// Table maps ranges to a label (like a hosting provider) with longest prefix match lookups,
+// the cost of a lookup only depends on the address length and not on the number of ranges
+type Table struct {
+	mu   sync.RWMutex
+	v4   *node
+	v6   *node
+	size int
+}
+
+// NewTable creates an empty table
+func NewTable() *Table {
+	return &Table{v4: &node{}, v6: &node{}}
+}
+
+// Insert adds the range with its label, a range inserted twice keeps the last label
+func (t *Table) Insert(prefix netip.Prefix, label string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addr := prefix.Addr().Unmap()
+	bits := prefix.Bits()
+	current := t.v6
+	if addr.Is4() {
+		current = t.v4
+		if prefix.Addr().Is4In6() {
+			bits -= 96
+		}
+	}
+	raw := addr.AsSlice()
+	for i := 0; i < bits; i++ {
+		bit := raw[i/8] >> (7 - i%8) & 1
+		if current.children[bit] == nil {
+			current.children[bit] = &node{}
+		}
+		current = current.children[bit]
+	}
+	if !current.set {
+		t.size++
+	}
+	current.label = label
+	current.set = true
+}
+
+// Lookup returns the label of the most specific range containing the ip
+func (t *Table) Lookup(ip string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	return t.LookupAddr(addr)
+}
+
+// LookupAddr is Lookup for an already parsed address
+func (t *Table) LookupAddr(addr netip.Addr) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	addr = addr.Unmap()
+	current := t.v6
+	if addr.Is4() {
+		current = t.v4
+	}
+	label, found := current.label, current.set
+	raw := addr.AsSlice()
+	for i := 0; i < len(raw)*8; i++ {
+		current = current.children[raw[i/8]>>(7-i%8)&1]
+		if current == nil {
+			break
+		}
+		if current.set {
+			label, found = current.label, true
+		}
+	}
+	return label, found
+}
+
+// Len returns the number of ranges in the table
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.size
+}
@@ -4,9 +4,22 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"reverseproxy/challenge"
+	"reverseproxy/eventlog"
+	"reverseproxy/firewall"
+	"reverseproxy/ipinfo"
+	"reverseproxy/ipranges"
+	"reverseproxy/rules"
+	"reverseproxy/tarpit"
+	"reverseproxy/trackers/ratelimit"
 
 	"github.com/google/uuid"
 	"golang.org/x/net/context"
@@ -16,13 +29,94 @@ var (
 	wg     sync.WaitGroup
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	shutdownHooksMu sync.Mutex
+	shutdownHooks   []func()
 )
 
+// onShutdown registers a function to run once the command exited, before banme exits
+func onShutdown(hook func()) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// runShutdownHooks runs the registered hooks, only the first call runs them
+func runShutdownHooks() {
+	shutdownHooksMu.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
 func main() {
 
 	disableBan := flag.Bool("disable-ban", false, "Disable the ban functionality just to audit the behaviour")
-	hit404threshold := flag.Int("hit-404-threshold", 50, "Threshold for 404 hits before taking action")
+	hit404threshold := flag.Int("hit-404-threshold", 50, "Threshold for the weighted status score (404 hits by default) before taking action")
+	hit404WindowInMinutes := flag.Int("hit-404-window-in-minutes", 5, "Sliding window in which the 404 hits are counted against the threshold")
+	statusWeightsFlag := flag.String("status-weights", "404=1", "Comma separated status=weight added to the score of the ip for each response with that status (ex: 404=1,401=3,400=0.5)")
 	banDurantionInMinutes := flag.Int("ban-duration-in-minutes", 1, "Threshold for 404 hits before taking action")
+	banEscalation := flag.String("ban-escalation", "", "Comma separated ban durations for repeat offenders (ex: 1m,10m,1h,24h), the last one is reused. Empty keeps -ban-duration-in-minutes for every ban")
+	banMaxDuration := flag.Duration("ban-max-duration", 24*time.Hour, "Cap on the duration of a single ban")
+	banHistoryTTL := flag.Duration("ban-history-ttl", 24*time.Hour, "Forget the offenses of an ip after this quiet period following its last ban")
+	subnetThreshold := flag.Float64("subnet-threshold", 0, "Threshold for the summed score of all the ips of a subnet before banning the whole subnet, 0 disables subnet bans")
+	subnetV4Prefix := flag.Int("subnet-v4-prefix", 24, "Prefix length used to aggregate ipv4 addresses into subnets")
+	subnetV6Prefix := flag.Int("subnet-v6-prefix", 64, "Prefix length used to aggregate ipv6 addresses into subnets")
+	rateLimit := flag.Float64("rate-limit", 0, "Requests per second allowed per ip (token bucket refill rate), 0 disables rate limiting")
+	rateBurst := flag.Int("rate-burst", 20, "Burst of requests allowed per ip above the rate limit")
+	rateLimitBanWeight := flag.Float64("rate-limit-ban-weight", 0, "Score added to the ip for each request rejected by the rate limit, 0 keeps rate limiting out of the ban logic")
+	routeLimitsFile := flag.String("route-limits", "", "Json file with rate limits per cleaned path: [{\"path\": \"/api/{id}/export.pdf\", \"perIp\": {\"rate\": 0.1, \"burst\": 2}, \"global\": {\"rate\": 1, \"burst\": 5}}]")
+	hostingRanges := flag.String("hosting-ranges", "", "Comma separated files or directories of hosting provider ranges (AWS ip-ranges.json, GCP cloud.json or one CIDR per line), the file name is the provider name")
+	hostingThreshold := flag.Int("hosting-threshold", 0, "Threshold for the score of ips from a hosting provider, 0 uses -hit-404-threshold")
+	geoIPCountryDB := flag.String("geoip-country-db", "", "GeoLite2-Country .mmdb file to tag the ips with their country")
+	geoIPASNDB := flag.String("geoip-asn-db", "", "GeoLite2-ASN .mmdb file to tag the ips with their autonomous system number and organization")
+	allowListFile := flag.String("allowlist", "", "File with the ips/CIDR ranges (one per line) that are never banned")
+	denyListFile := flag.String("denylist", "", "File with the ips/CIDR ranges (one per line) that are always refused with a 403")
+	reloadInterval := flag.Duration("reload-interval", 10*time.Second, "How often the list files are checked for changes")
+	rulePacks := flag.String("rule-packs", "", "Comma separated built-in rule packs to enable ("+strings.Join(rules.PackNames(), ",")+")")
+	rulesFile := flag.String("rules-file", "", "Json file with additional path rules: [{\"name\": \"...\", \"glob\" or \"regex\": \"...\", \"action\": \"ban\", \"score\" or \"block\", \"weight\": 10, \"hosting\": true, \"countries\": [\"XX\"], \"asns\": [1234], \"statuses\": [404], \"shadow\": true, \"response\": \"tarpit\"}]")
+	shadowRules := flag.String("shadow-rules", "", "Comma separated names of rules (from the packs or -rules-file) to run in shadow mode: their decisions are recorded on the dashboard but not enforced")
+	shadowThreshold := flag.Float64("shadow-threshold", 0, "Record on the dashboard the ips whose score goes above this threshold without banning them, to try a new -hit-404-threshold. 0 disables it")
+	honeypotPaths := flag.String("honeypot-paths", "", "Comma separated trap paths, any client requesting them is banned right away (the backend never sees them)")
+	honeypotRobots := flag.Bool("honeypot-robots", false, "Serve a generated /robots.txt disallowing the honeypot paths instead of the backend one")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated ips/CIDR ranges of the proxies allowed to set the client ip through -forwarded-header or -client-ip-header. Without it these headers are ignored")
+	forwardedHeader := flag.String("forwarded-header", ForwardedXFF, "Forwarding header written by the trusted proxies: x-forwarded-for, forwarded or x-real-ip. Only this one is read, the others may come from the client")
+	clientIPHeader := flag.String("client-ip-header", "", "Vendor header set by the trusted proxies with the client ip (ex: CF-Connecting-IP)")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "Accept HAProxy PROXY protocol v1/v2 headers on the listener to get the real client address behind a TCP load balancer")
+	proxyProtocolTrusted := flag.String("proxy-protocol-trusted", "", "Comma separated ips/CIDR ranges of the load balancers allowed to send PROXY protocol headers, required with -proxy-protocol")
+	stateFile := flag.String("state-file", "", "File where bans, offenses and per ip counters are saved to survive restarts")
+	stateSaveInterval := flag.Duration("state-save-interval", time.Minute, "How often the state is saved to -state-file, it is also saved at shutdown")
+	pruneAfter := flag.Duration("prune-after", 24*time.Hour, "Forget the counters of ips not seen for this long")
+	maxTrackedIPs := flag.Int("max-tracked-ips", 100000, "Cap on the number of tracked ips, the least recently seen are evicted first. 0 disables the cap")
+	cleanupInterval := flag.Duration("cleanup-interval", time.Minute, "How often idle ips are pruned")
+	firewallBackend := flag.String("firewall", "", "Mirror the bans into the kernel firewall: nftables or ipset. The sets <firewall-set>4 and <firewall-set>6 are created, dropping their traffic is left to your firewall rules")
+	firewallSet := flag.String("firewall-set", "banme", "Name of the nftables table and prefix of the nftables/ipset sets")
+	firewallDryRun := flag.Bool("firewall-dry-run", false, "Log the firewall commands instead of running them (no root needed)")
+	eventLogFile := flag.String("event-log", "", "File where the 404s, rule hits and bans are appended in a stable format for fail2ban or CrowdSec (see contrib/), - for stdout")
+	bannedResponse := flag.String("banned-response", "forbidden", "How banned clients are answered: forbidden (immediate 403), tarpit (drip the response byte by byte) or delay (403 after -tarpit-delay). Rules can override it with \"response\"")
+	tarpitMaxConnections := flag.Int("tarpit-max-connections", 100, "Cap on the connections held by the tarpit and delay responses at once, the others get an immediate 403")
+	tarpitDelay := flag.Duration("tarpit-delay", 10*time.Second, "How long the delay response waits before the 403")
+	tarpitDripInterval := flag.Duration("tarpit-drip-interval", time.Second, "Pause between two bytes of the tarpit response")
+	tarpitDuration := flag.Duration("tarpit-duration", 2*time.Minute, "How long a tarpit response lasts")
+	blockStatus := flag.Int("block-status", http.StatusForbidden, "Status of the responses to banned and blocked clients: 403, 429 or 444 (close the connection without answering)")
+	blockTemplate := flag.String("block-template", "", "Html (or .json) Go template of the responses to banned and blocked clients, with .IP, .Reason, .Until, .Remaining and .Reference (a support id logged in the access log)")
+	challengeThreshold := flag.Float64("challenge-threshold", 0, "Score from which clients must solve a javascript proof of work before reaching the backend, below -hit-404-threshold to challenge the grey zone. 0 disables the challenge")
+	challengeDifficulty := flag.Int("challenge-difficulty", 16, "Leading zero bits of the proof of work (1 to 32), each extra bit doubles the solving time")
+	challengeTTL := flag.Duration("challenge-ttl", time.Hour, "Validity of the cookie given once the challenge is solved")
+	challengeWeight := flag.Float64("challenge-weight", 1, "Score added to the ip each time it is sent to the challenge, so clients never solving it end up banned")
+	listen := flag.String("listen", ":8000", "Address the proxy listens on")
+	peerURLs := flag.String("peers", "", "Comma separated admin urls of the other banme instances to share bans with (ex: http://10.0.0.2:8000), authenticated with BANME_ADMIN_PASSWORD unless the url has credentials")
+	peerSyncInterval := flag.Duration("peer-sync-interval", 10*time.Second, "How often the bans of the peers are pulled, they are also pushed as they happen")
+	nodeName := flag.String("node-name", "", "Name of this instance recorded as origin of the bans it shares, defaults to the hostname and listen address")
+	backendBudgetWindow := flag.Duration("backend-budget-window", 5*time.Minute, "Sliding window in which the backend time of each ip is summed")
+	backendBudgetShare := flag.Float64("backend-budget-share", 0, "Share (0-1) of the backend time of all the ips a single ip may use in the window before being throttled or banned, 0 disables the budget")
+	backendBudgetMinSeconds := flag.Float64("backend-budget-min-seconds", 60, "Backend seconds of all the ips in the window below which the budget is not enforced")
+	backendBudgetAction := flag.String("backend-budget-action", "throttle", "What happens to the ips over budget: throttle (429) or ban")
+	maxConcurrentPerIP := flag.Int("max-concurrent-per-ip", 0, "Cap on the in-flight requests of a single ip, over it requests wait for -concurrency-queue-timeout then get a 429. 0 disables the cap")
+	concurrencyQueueTimeout := flag.Duration("concurrency-queue-timeout", 0, "How long a request over -max-concurrent-per-ip waits for a slot, 0 refuses it right away")
 	modifyHost := flag.Bool("modify-host", false, "modify the Host in url based on BACKEND_URL")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s: %s\n", os.Args[0], "followed by some option flags and the command to launch/proxy")
@@ -50,6 +144,56 @@ func main() {
 		fmt.Println("to access /__banme/ console and api use generated a default password for admin ", adminPassword)
 	}
 
+	var challenger *challenge.Challenger
+	if *challengeThreshold > 0 {
+		// BANME_CHALLENGE_SECRET keeps the cookies valid across restarts and replicas
+		challenger = challenge.New(os.Getenv("BANME_CHALLENGE_SECRET"), *challengeDifficulty, *challengeTTL)
+	}
+
+	if *hit404WindowInMinutes <= 0 {
+		log.Fatalf("Invalid -hit-404-window-in-minutes %d, expected more than 0", *hit404WindowInMinutes)
+	}
+	if *subnetV4Prefix < 1 || *subnetV4Prefix > 32 {
+		log.Fatalf("Invalid -subnet-v4-prefix %d, expected 1 to 32", *subnetV4Prefix)
+	}
+	if *subnetV6Prefix < 1 || *subnetV6Prefix > 128 {
+		log.Fatalf("Invalid -subnet-v6-prefix %d, expected 1 to 128", *subnetV6Prefix)
+	}
+	if *challengeDifficulty < 1 || *challengeDifficulty > 32 {
+		log.Fatalf("Invalid -challenge-difficulty %d, expected 1 to 32", *challengeDifficulty)
+	}
+	if *challengeTTL <= 0 {
+		log.Fatalf("Invalid -challenge-ttl %v, expected more than 0", *challengeTTL)
+	}
+	if *tarpitMaxConnections < 0 {
+		log.Fatalf("Invalid -tarpit-max-connections %d, expected 0 or more", *tarpitMaxConnections)
+	}
+	if *tarpitDelay < 0 {
+		log.Fatalf("Invalid -tarpit-delay %v, expected 0 or more", *tarpitDelay)
+	}
+	if *tarpitDripInterval <= 0 {
+		log.Fatalf("Invalid -tarpit-drip-interval %v, expected more than 0", *tarpitDripInterval)
+	}
+	if *tarpitDuration <= 0 {
+		log.Fatalf("Invalid -tarpit-duration %v, expected more than 0", *tarpitDuration)
+	}
+
+	if *backendBudgetShare < 0 || *backendBudgetShare > 1 {
+		log.Fatalf("Invalid -backend-budget-share %v, expected 0 to 1", *backendBudgetShare)
+	}
+	if *backendBudgetAction != "throttle" && *backendBudgetAction != "ban" {
+		log.Fatalf("Invalid -backend-budget-action %q, expected throttle or ban", *backendBudgetAction)
+	}
+
+	if !IsForwardedHeader(*forwardedHeader) {
+		log.Fatalf("Invalid -forwarded-header %q, expected x-forwarded-for, forwarded or x-real-ip", *forwardedHeader)
+	}
+
+	if *nodeName == "" {
+		hostname, _ := os.Hostname()
+		*nodeName = hostname + *listen
+	}
+
 	backendURLStr := os.Getenv("BANME_BACKEND_URL")
 	if backendURLStr == "" {
 		backendURLStr = "http://localhost:8080"
@@ -59,7 +203,241 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to parse backend URL: %v", err)
 	}
-	serve(backendURL, *disableBan, *hit404threshold, *banDurantionInMinutes, *modifyHost, adminPassword)
+	banSteps, err := parseDurations(*banEscalation)
+	if err != nil {
+		log.Fatalf("Failed to parse -ban-escalation: %v", err)
+	}
+
+	allowList := loadRangesFile(*allowListFile, *reloadInterval)
+	denyList := loadRangesFile(*denyListFile, *reloadInterval)
+
+	trustedProxyList, err := ipranges.ParseList(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to parse -trusted-proxies: %v", err)
+	}
+
+	proxyProtocolTrustedList, err := ipranges.ParseList(*proxyProtocolTrusted)
+	if err != nil {
+		log.Fatalf("Failed to parse -proxy-protocol-trusted: %v", err)
+	}
+	if *proxyProtocol && proxyProtocolTrustedList.Len() == 0 {
+		log.Fatalf("-proxy-protocol requires -proxy-protocol-trusted, otherwise any client could announce any address")
+	}
+
+	statusWeights, err := parseStatusWeights(*statusWeightsFlag)
+	if err != nil {
+		log.Fatalf("Failed to parse -status-weights: %v", err)
+	}
+
+	var routeLimits []ratelimit.RouteLimit
+	if *routeLimitsFile != "" {
+		routeLimits, err = ratelimit.LoadRouteLimits(*routeLimitsFile)
+		if err != nil {
+			log.Fatalf("Failed to load route limits: %v", err)
+		}
+	}
+
+	var hosting *ipinfo.Hosting
+	if *hostingRanges != "" {
+		hosting, err = ipinfo.NewHosting(strings.Split(*hostingRanges, ","))
+		if err != nil {
+			log.Fatalf("Failed to load hosting ranges: %v", err)
+		}
+		log.Printf("Loaded %d hosting ranges", hosting.Len())
+		go hosting.Watch(ctx, *reloadInterval)
+	}
+
+	var geoIP *ipinfo.GeoIP
+	if *geoIPCountryDB != "" || *geoIPASNDB != "" {
+		geoIP, err = ipinfo.NewGeoIP(*geoIPCountryDB, *geoIPASNDB)
+		if err != nil {
+			log.Fatalf("Failed to load geoip databases: %v", err)
+		}
+		go geoIP.Watch(ctx, *reloadInterval)
+	}
+
+	var firewallMirror firewall.Backend
+	if *firewallBackend != "" {
+		firewallMirror, err = firewall.New(*firewallBackend, *firewallSet, *firewallDryRun)
+		if err != nil {
+			log.Fatalf("Failed to configure the firewall: %v", err)
+		}
+		if err := firewallMirror.Setup(); err != nil {
+			log.Fatalf("Failed to create the firewall sets: %v", err)
+		}
+	}
+
+	defaultResponse, err := rules.ParseResponse(*bannedResponse)
+	if err != nil {
+		log.Fatalf("Invalid -banned-response: %v", err)
+	}
+
+	blockPage, err := NewBlockPage(*blockStatus, *blockTemplate)
+	if err != nil {
+		log.Fatalf("Failed to load the block page: %v", err)
+	}
+
+	var eventLog *eventlog.Logger
+	if *eventLogFile != "" {
+		eventLog, err = eventlog.Open(*eventLogFile)
+		if err != nil {
+			log.Fatalf("Failed to open the event log: %v", err)
+		}
+	}
+
+	ruleEngine, err := loadRules(*rulePacks, *rulesFile, *shadowRules)
+	if err != nil {
+		log.Fatalf("Failed to load rules: %v", err)
+	}
+
+	serve(backendURL, ServeConfig{
+		DisableBan:            *disableBan,
+		Hit404Threshold:       *hit404threshold,
+		Hit404WindowInMinutes: *hit404WindowInMinutes,
+		ShadowThreshold:       *shadowThreshold,
+		StatusWeights:         statusWeights,
+		BanDurationInMinutes:  *banDurantionInMinutes,
+		BanEscalation:         banSteps,
+		BanMaxDuration:        *banMaxDuration,
+		BanHistoryTTL:         *banHistoryTTL,
+		SubnetThreshold:       *subnetThreshold,
+		SubnetV4Prefix:        *subnetV4Prefix,
+		SubnetV6Prefix:        *subnetV6Prefix,
+		RateLimit:             *rateLimit,
+		RateBurst:             *rateBurst,
+		RateLimitBanWeight:    *rateLimitBanWeight,
+		RouteLimits:           routeLimits,
+		AllowList:             allowList,
+		DenyList:              denyList,
+		Rules:                 ruleEngine,
+		Honeypot:              rules.NewHoneypot(*honeypotPaths),
+		HoneypotRobots:        *honeypotRobots,
+		TrustedProxies:        trustedProxyList,
+		ClientIPHeader:        *clientIPHeader,
+		ForwardedHeader:       *forwardedHeader,
+		Hosting:               hosting,
+		HostingThreshold:      *hostingThreshold,
+		GeoIP:                 geoIP,
+		ProxyProtocol:         *proxyProtocol,
+		ProxyProtocolTrusted:  proxyProtocolTrustedList,
+		StateFile:             *stateFile,
+		StateSaveInterval:     *stateSaveInterval,
+		PruneAfter:            *pruneAfter,
+		MaxTrackedIPs:         *maxTrackedIPs,
+		CleanupInterval:       *cleanupInterval,
+		Firewall:              firewallMirror,
+		EventLog:              eventLog,
+		BannedResponse:        defaultResponse,
+		BlockPage:             blockPage,
+		Challenger:            challenger,
+		ChallengeThreshold:    *challengeThreshold,
+		ChallengeWeight:       *challengeWeight,
+		Listen:                *listen,
+		Peers:                 strings.Split(*peerURLs, ","),
+		PeerSyncInterval:      *peerSyncInterval,
+		NodeName:              *nodeName,
+		BackendBudgetWindow:   *backendBudgetWindow,
+		BackendBudgetShare:    *backendBudgetShare,
+		BackendMinSeconds:     *backendBudgetMinSeconds,
+		BackendBudgetBan:      *backendBudgetAction == "ban",
+		MaxConcurrentPerIP:    *maxConcurrentPerIP,
+		ConcurrencyQueue:      *concurrencyQueueTimeout,
+		Tarpit:                tarpit.New(*tarpitMaxConnections, *tarpitDelay, *tarpitDripInterval, *tarpitDuration),
+		ModifyHost:            *modifyHost,
+		AdminPassword:         adminPassword,
+	})
 
 	wg.Wait()
 }
+
+// parseDurations parses a comma separated list of durations like "1m,10m,1h"
+func parseDurations(value string) ([]time.Duration, error) {
+	var durations []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(part)
+		if err != nil {
+			return nil, err
+		}
+		if duration <= 0 {
+			// a ban ending before it starts would never apply but still count as an offense
+			return nil, fmt.Errorf("invalid duration %q, expected more than 0", part)
+		}
+		durations = append(durations, duration)
+	}
+	return durations, nil
+}
+
+// parseStatusWeights parses a comma separated list of status=weight like "404=1,401=3,400=0.5"
+func parseStatusWeights(value string) (map[int]float64, error) {
+	weights := make(map[int]float64)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		status, weight, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("missing weight in %q", part)
+		}
+		statusCode, err := strconv.Atoi(strings.TrimSpace(status))
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("invalid status in %q", part)
+		}
+		statusWeight, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil || statusWeight < 0 {
+			return nil, fmt.Errorf("invalid weight in %q", part)
+		}
+		weights[statusCode] = statusWeight
+	}
+	return weights, nil
+}
+
+// loadRangesFile loads the ranges file if any and reloads it on changes
+func loadRangesFile(path string, reloadInterval time.Duration) *ipranges.List {
+	if path == "" {
+		return nil
+	}
+	list, err := ipranges.LoadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to load ranges: %v", err)
+	}
+	log.Printf("Loaded %s with %d ranges", path, list.Len())
+	go list.Watch(ctx, path, reloadInterval)
+	return list
+}
+
+// loadRules combines the enabled built-in packs with the rules of the file
+func loadRules(packs string, path string, shadow string) (*rules.Engine, error) {
+	enabled, err := rules.Packs(packs)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		custom, err := rules.LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		enabled = append(enabled, custom...)
+	}
+	for _, name := range strings.Split(shadow, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for i := range enabled {
+			if enabled[i].Name == name {
+				enabled[i].Shadow = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown shadow rule %q", name)
+		}
+	}
+	return rules.NewEngine(enabled)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatusWeights(t *testing.T) {
+	weights, err := parseStatusWeights("404=1, 401=3,400=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]float64{404: 1, 401: 3, 400: 0.5}
+	if !reflect.DeepEqual(weights, expected) {
+		t.Errorf("parseStatusWeights() = %v, want %v", weights, expected)
+	}
+
+	for _, invalid := range []string{"404", "abc=1", "404=x", "404=-1", "42=1"} {
+		if _, err := parseStatusWeights(invalid); err == nil {
+			t.Errorf("parseStatusWeights(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	durations, err := parseDurations("1m, 10m,1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("parseDurations() = %v, want %v", durations, expected)
+	}
+
+	for _, invalid := range []string{"1x", "0s,1h", "-1m", "1m,0"} {
+		if _, err := parseDurations(invalid); err == nil {
+			t.Errorf("parseDurations(%q) should fail", invalid)
+		}
+	}
+}
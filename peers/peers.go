@@ -0,0 +1,315 @@
+package peers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reverseproxy/trackers/ip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventsPath is the admin endpoint where the peers push and pull the ban events
+const EventsPath = "/__banme/api/peers/events"
+
+// maxJournal bounds the local events kept for the peers pulling them, a peer down for longer gets the last ones
+const maxJournal = 10000
+
+// pushQueue bounds the events waiting to be pushed to a peer, the ones over it are left to the next pull
+const pushQueue = 1000
+
+// Event is a local ban event numbered for the peers pulling them
+type Event struct {
+	Seq uint64 `json:"seq"`
+	ip.BanEvent
+}
+
+// Batch is the payload pushed to the peers or returned when pulled
+type Batch struct {
+	Origin string `json:"origin"`
+	// Instance identifies the process of the origin, its sequence numbers restart with it
+	Instance int64 `json:"instance"`
+	// Seq is the last sequence number of the origin, the cursor of the next pull
+	Seq    uint64  `json:"seq"`
+	Events []Event `json:"events"`
+}
+
+// peer is another instance, reached through its admin url
+type peer struct {
+	url      string
+	username string
+	password string
+	queue    chan Event
+
+	mu       sync.Mutex
+	cursor   uint64
+	instance int64
+	dropped  int
+	origin   string
+	up       bool
+	checked  bool
+	lastSync time.Time
+}
+
+// Replicator shares the bans decided by this instance with its peers and merges theirs in the tracker.
+//
+// Local events are pushed right away and kept in a journal the peers pull every interval, so a peer
+// coming back gets what it missed. The pushes go through a bounded queue and a worker per peer, a ban
+// storm is sent in a few batches instead of one request per event. Every instance must list all the others: only local decisions are
+// replicated, not the ones received from a peer.
+type Replicator struct {
+	Name     string
+	instance int64
+	tracker  *ip.IPTracker
+	peers    []*peer
+	client   *http.Client
+
+	mu      sync.Mutex
+	seq     uint64
+	journal []Event
+}
+
+// New replicates with the peers admin urls, authenticated as admin with password unless the url has its own credentials
+func New(name string, peerURLs []string, password string, tracker *ip.IPTracker) (*Replicator, error) {
+	r := &Replicator{Name: name, instance: time.Now().UnixNano(), tracker: tracker, client: &http.Client{Timeout: 5 * time.Second}}
+	for _, raw := range peerURLs {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid peer url %q", raw)
+		}
+		p := &peer{username: "admin", password: password, queue: make(chan Event, pushQueue)}
+		if parsed.User != nil {
+			p.username = parsed.User.Username()
+			p.password, _ = parsed.User.Password()
+			parsed.User = nil
+		}
+		p.url = strings.TrimSuffix(parsed.String(), "/")
+		r.peers = append(r.peers, p)
+		go r.pushLoop(p)
+	}
+	return r, nil
+}
+
+// Record journals a local ban event and queues it for the peers, events received from a peer are skipped.
+// It's meant to be registered with IPTracker.OnBan.
+func (r *Replicator) Record(event ip.BanEvent) {
+	if event.Origin != "" {
+		return
+	}
+	r.mu.Lock()
+	r.seq++
+	journaled := Event{Seq: r.seq, BanEvent: event}
+	r.journal = append(r.journal, journaled)
+	if len(r.journal) > maxJournal {
+		r.journal = r.journal[len(r.journal)-maxJournal:]
+	}
+	r.mu.Unlock()
+
+	for _, p := range r.peers {
+		select {
+		case p.queue <- journaled:
+		default:
+			p.mu.Lock()
+			p.dropped++
+			p.mu.Unlock()
+		}
+	}
+}
+
+// since returns the journaled events after seq, all of them when seq is from another instance (before a restart)
+func (r *Replicator) since(seq uint64, instance int64) Batch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if instance != r.instance || seq > r.seq {
+		seq = 0
+	}
+	events := []Event{}
+	for _, event := range r.journal {
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return Batch{Origin: r.Name, Instance: r.instance, Seq: r.seq, Events: events}
+}
+
+// apply merges the events of a peer in the tracker
+func (r *Replicator) apply(batch Batch) {
+	if batch.Origin == "" || batch.Origin == r.Name {
+		return
+	}
+	for _, event := range batch.Events {
+		if err := r.tracker.ApplyRemote(event.BanEvent, batch.Origin); err != nil {
+			log.Printf("Failed to apply the %s event of %s from %s: %v", event.Kind, event.Target, batch.Origin, err)
+		}
+	}
+}
+
+// Handler serves the journal to the peers pulling it (GET ?since=seq&instance=id) and receives their pushes (POST)
+func (r *Replicator) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			since, _ := strconv.ParseUint(req.URL.Query().Get("since"), 10, 64)
+			instance, _ := strconv.ParseInt(req.URL.Query().Get("instance"), 10, 64)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(r.since(since, instance))
+		case http.MethodPost:
+			var batch Batch
+			if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+				http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.apply(batch)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (r *Replicator) do(p *peer, method string, target string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.username, p.password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	response, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, target, response.Status)
+	}
+	return response, nil
+}
+
+// pushLoop sends the queued events to the peer, the ones queued meanwhile go in the same batch
+func (r *Replicator) pushLoop(p *peer) {
+	for event := range p.queue {
+		events := []Event{event}
+	drain:
+		for len(events) < pushQueue {
+			select {
+			case event := <-p.queue:
+				events = append(events, event)
+			default:
+				break drain
+			}
+		}
+		r.push(p, Batch{Origin: r.Name, Instance: r.instance, Seq: events[len(events)-1].Seq, Events: events})
+	}
+}
+
+func (r *Replicator) push(p *peer, batch Batch) {
+	body, _ := json.Marshal(batch)
+	response, err := r.do(p, http.MethodPost, p.url+EventsPath, body)
+	if err == nil {
+		response.Body.Close()
+	}
+	// the events missed by a down peer are pulled once it's back
+	p.setUp(err)
+}
+
+// pull fetches the events of the peer since the last pull
+func (r *Replicator) pull(p *peer) {
+	p.mu.Lock()
+	cursor := p.cursor
+	instance := p.instance
+	p.mu.Unlock()
+
+	query := "?since=" + strconv.FormatUint(cursor, 10) + "&instance=" + strconv.FormatInt(instance, 10)
+	response, err := r.do(p, http.MethodGet, p.url+EventsPath+query, nil)
+	if err != nil {
+		p.setUp(err)
+		return
+	}
+	defer response.Body.Close()
+	var batch Batch
+	if err := json.NewDecoder(response.Body).Decode(&batch); err != nil {
+		p.setUp(err)
+		return
+	}
+	r.apply(batch)
+
+	p.mu.Lock()
+	p.cursor = batch.Seq
+	p.instance = batch.Instance
+	p.origin = batch.Origin
+	p.lastSync = time.Now()
+	p.mu.Unlock()
+	p.setUp(nil)
+}
+
+// setUp logs when the peer goes down or comes back
+func (p *peer) setUp(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil && (p.up || !p.checked) {
+		log.Printf("Peer %s is down: %v", p.url, err)
+	} else if err == nil && !p.up {
+		log.Printf("Peer %s is up", p.url)
+	}
+	p.up = err == nil
+	p.checked = true
+}
+
+// Sync pulls the events of all the peers
+func (r *Replicator) Sync() {
+	var wg sync.WaitGroup
+	for _, p := range r.peers {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			r.pull(p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// Run pulls the peers every interval until ctx is done
+func (r *Replicator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	r.Sync()
+	for {
+		select {
+		case <-ticker.C:
+			r.Sync()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Info returns the state of the peers for the dashboard
+func (r *Replicator) Info() map[string]interface{} {
+	peers := make(map[string]interface{}, len(r.peers))
+	for _, p := range r.peers {
+		p.mu.Lock()
+		peers[p.url] = map[string]interface{}{
+			"up":       p.up,
+			"origin":   p.origin,
+			"cursor":   p.cursor,
+			"dropped":  p.dropped,
+			"lastSync": p.lastSync,
+		}
+		p.mu.Unlock()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return map[string]interface{}{"name": r.Name, "seq": r.seq, "peers": peers}
+}
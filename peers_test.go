@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reverseproxy/peers"
+	"reverseproxy/trackers/ip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls the condition for up to a second
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// newPeer starts an instance replicating with the peer urls, its own url is returned once listening
+func newPeer(t *testing.T, name string, peerURLs ...string) (*ip.IPTracker, *peers.Replicator, *httptest.Server) {
+	tracker := ip.NewIPTracker(5, time.Minute, time.Hour)
+	replicator, err := peers.New(name, peerURLs, "peer-secret", tracker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.OnBan(replicator.Record)
+	mux := http.NewServeMux()
+	mux.Handle(peers.EventsPath, AuthMiddleware(replicator.Handler()))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return tracker, replicator, server
+}
+
+func TestPeersReplication(t *testing.T) {
+	globalAdminPassword = "peer-secret"
+
+	// b is not started yet: a pushes to a down peer, b pulls what it missed once up
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+	trackerA, replicatorA, serverA := newPeer(t, "a", downURL)
+	trackerA.Ban("192.0.2.1", ip.ReasonHoneypot)
+	trackerA.ManualBan("198.51.100.0/24", time.Hour, "scanner")
+	replicatorA.Sync()
+
+	trackerB, replicatorB, serverB := newPeer(t, "b", serverA.URL)
+	replicatorB.Sync()
+	ban, banned := trackerB.BanOf("192.0.2.1")
+	if !banned || ban.Origin != "a" || ban.Reason != ip.ReasonHoneypot {
+		t.Errorf("BanOf() on b = %+v, %v, want the honeypot ban of a", ban, banned)
+	}
+	if !trackerB.CheckBan("198.51.100.7") {
+		t.Errorf("the range ban of a should apply on b")
+	}
+	// bans received from a peer are not shared again
+	if seq := replicatorB.Info()["seq"]; seq != uint64(0) {
+		t.Errorf("seq of b = %v, want 0", seq)
+	}
+
+	// a now knows b: pushes are applied right away
+	replicatorA, err := peers.New("a", []string{serverB.URL}, "peer-secret", trackerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trackerA.OnBan(replicatorA.Record)
+	trackerA.Unban("192.0.2.1")
+	if !waitFor(func() bool { return !trackerB.CheckBan("192.0.2.1") }) {
+		t.Errorf("the unban of a should be pushed to b")
+	}
+
+	// wrong credentials are refused
+	wrong, _ := peers.New("intruder", []string{"http://admin:nope@" + serverB.Listener.Addr().String()}, "", ip.NewIPTracker(5, time.Minute, time.Hour))
+	wrong.Sync()
+	if up := wrong.Info()["peers"].(map[string]interface{})[serverB.URL].(map[string]interface{})["up"]; up != false {
+		t.Errorf("peer with wrong credentials should be down")
+	}
+}
+
+func TestPeersRestart(t *testing.T) {
+	globalAdminPassword = "peer-secret"
+
+	// the handler is swapped to restart a behind the same url
+	var mu sync.Mutex
+	var handler http.Handler
+	serverA := httptest.NewServer(AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current := handler
+		mu.Unlock()
+		current.ServeHTTP(w, r)
+	})))
+	t.Cleanup(serverA.Close)
+	start := func() *ip.IPTracker {
+		tracker := ip.NewIPTracker(5, time.Minute, time.Hour)
+		replicator, _ := peers.New("a", nil, "peer-secret", tracker)
+		tracker.OnBan(replicator.Record)
+		mu.Lock()
+		handler = replicator.Handler()
+		mu.Unlock()
+		return tracker
+	}
+
+	trackerA := start()
+	for _, target := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		trackerA.ManualBan(target, time.Hour, "")
+	}
+	trackerB, replicatorB, _ := newPeer(t, "b", serverA.URL)
+	if !waitFor(func() bool { replicatorB.Sync(); return trackerB.CheckBan("192.0.2.3") }) {
+		t.Fatalf("b should pull the bans of a")
+	}
+
+	// the restarted a numbers its events from 1 again, past the cursor of b
+	trackerA = start()
+	for _, target := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4"} {
+		trackerA.ManualBan(target, time.Hour, "")
+	}
+	if !waitFor(func() bool { replicatorB.Sync(); return trackerB.CheckBan("198.51.100.4") }) {
+		t.Fatalf("b should pull the bans of the restarted a")
+	}
+	if !trackerB.CheckBan("198.51.100.1") {
+		t.Errorf("the events of the restarted a below the old cursor should not be skipped")
+	}
+}
+
+func TestPeersDisableBan(t *testing.T) {
+	for _, disableBan := range []bool{true, false} {
+		tracker := ip.NewIPTracker(5, time.Minute, time.Hour)
+		replicator, err := peers.New("audit", nil, "peer-secret", tracker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		watchBans(tracker, replicator, ServeConfig{DisableBan: disableBan})
+		tracker.Ban("192.0.2.1", ip.ReasonHoneypot)
+
+		journaled := waitFor(func() bool { return replicator.Info()["seq"] == uint64(1) })
+		if journaled == disableBan {
+			t.Errorf("journaled = %v with DisableBan = %v, a -disable-ban node should share nothing", journaled, disableBan)
+		}
+	}
+}
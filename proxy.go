@@ -6,19 +6,31 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"reverseproxy/challenge"
 	"reverseproxy/diagnoses/pg"
-	"strings"
+	"reverseproxy/eventlog"
+	"reverseproxy/firewall"
+	"reverseproxy/ipinfo"
+	"reverseproxy/ipranges"
+	"reverseproxy/peers"
+	"reverseproxy/proxyproto"
+	"reverseproxy/rules"
+	"reverseproxy/tarpit"
+	"strconv"
 	"time"
 
 	"reverseproxy/trackers/active"
+	"reverseproxy/trackers/backendcost"
 	"reverseproxy/trackers/buckets"
 	"reverseproxy/trackers/ip"
 	"reverseproxy/trackers/lastrequests"
+	"reverseproxy/trackers/ratelimit"
 )
 
 // Embed the entire "static" folder.
@@ -56,13 +68,206 @@ func getScheme(r *http.Request) string {
 	return "http"
 }
 
-func serve(backendURL *url.URL, disableBan bool, hit404threshold int, banDurantionInMinutes int, modifyHost bool, adminPassword string) {
-	globalAdminPassword = adminPassword
+// ServeConfig holds the options of the proxy, mostly coming from the command line flags
+type ServeConfig struct {
+	DisableBan            bool
+	Hit404Threshold       int
+	Hit404WindowInMinutes int
+	ShadowThreshold       float64
+	StatusWeights         map[int]float64
+	BanDurationInMinutes  int
+	BanEscalation         []time.Duration
+	BanMaxDuration        time.Duration
+	BanHistoryTTL         time.Duration
+	SubnetThreshold       float64
+	SubnetV4Prefix        int
+	SubnetV6Prefix        int
+	RateLimit             float64
+	RateBurst             int
+	RateLimitBanWeight    float64
+	RouteLimits           []ratelimit.RouteLimit
+	AllowList             *ipranges.List
+	DenyList              *ipranges.List
+	Rules                 *rules.Engine
+	Honeypot              *rules.Honeypot
+	HoneypotRobots        bool
+	TrustedProxies        *ipranges.List
+	ClientIPHeader        string
+	ForwardedHeader       string
+	Hosting               *ipinfo.Hosting
+	HostingThreshold      int
+	GeoIP                 *ipinfo.GeoIP
+	ProxyProtocol         bool
+	ProxyProtocolTrusted  *ipranges.List
+	StateFile             string
+	StateSaveInterval     time.Duration
+	PruneAfter            time.Duration
+	MaxTrackedIPs         int
+	CleanupInterval       time.Duration
+	Firewall              firewall.Backend
+	EventLog              *eventlog.Logger
+	BannedResponse        rules.Response
+	Tarpit                *tarpit.Tarpit
+	BlockPage             *BlockPage
+	Challenger            *challenge.Challenger
+	ChallengeThreshold    float64
+	ChallengeWeight       float64
+	Listen                string
+	Peers                 []string
+	PeerSyncInterval      time.Duration
+	NodeName              string
+	BackendBudgetWindow   time.Duration
+	BackendBudgetShare    float64
+	BackendMinSeconds     float64
+	BackendBudgetBan      bool
+	MaxConcurrentPerIP    int
+	ConcurrencyQueue      time.Duration
+	ModifyHost            bool
+	AdminPassword         string
+}
+
+// recordShadowRule records what the rule would have done, score rules only when they would have banned the ip
+func recordShadowRule(tracker *ip.IPTracker, events *eventlog.Logger, rule *rules.Rule, clientIP string, r *http.Request) {
+	action := string(rule.Action)
+	if rule.Action == rules.ActionScore {
+		if !tracker.WouldBan(clientIP, rule.Weight) {
+			return
+		}
+		action = string(rules.ActionBan)
+	}
+	log.Printf("Access log: method=%s url=%s ip=%s rule=%s action=%s (shadow)", r.Method, r.URL.String(), clientIP, rule.Name, action)
+	tracker.RecordShadow(clientIP, "rule:"+rule.Name, action, r.URL.Path)
+	events.Shadow(clientIP, "rule:"+rule.Name, action, r.URL.Path)
+}
+
+// applyRules bans or scores the ip for the matched rules, it returns the first rule blocking the request
+func applyRules(tracker *ip.IPTracker, events *eventlog.Logger, matched []*rules.Rule, clientIP string, r *http.Request) *rules.Rule {
+	var blocked *rules.Rule
+	for _, rule := range matched {
+		if rule.Shadow {
+			recordShadowRule(tracker, events, rule, clientIP, r)
+			continue
+		}
+		log.Printf("Access log: method=%s url=%s ip=%s rule=%s action=%s", r.Method, r.URL.String(), clientIP, rule.Name, rule.Action)
+		events.Rule(clientIP, rule.Name, string(rule.Action), r.URL.Path)
+		switch rule.Action {
+		case rules.ActionBan:
+			tracker.Ban(clientIP, "rule:"+rule.Name)
+		case rules.ActionScore:
+			tracker.AddScore(clientIP, rule.Weight)
+		case rules.ActionBlock:
+			if blocked == nil {
+				blocked = rule
+			}
+		}
+	}
+	return blocked
+}
+
+// mirrorBan pushes the bans and unbans of the tracker to the firewall
+func mirrorBan(backend firewall.Backend) func(ip.BanEvent) {
+	return func(event ip.BanEvent) {
+		var err error
+		if event.Unban {
+			err = backend.Unban(event.Target)
+		} else if remaining := event.Ban.Remaining(); remaining > 0 {
+			err = backend.Ban(event.Target, remaining)
+		}
+		if err != nil {
+			log.Printf("Failed to mirror the %s of %s to the firewall: %v", event.Kind, event.Target, err)
+		}
+	}
+}
+
+// watchBans registers the listeners of the bans of the tracker. With DisableBan the bans are
+// only logged: they are neither mirrored to the firewall nor shared with the peers enforcing them.
+func watchBans(tracker *ip.IPTracker, replicator *peers.Replicator, config ServeConfig) {
+	if config.EventLog != nil {
+		tracker.OnBan(logBan(config.EventLog))
+	}
+	if config.DisableBan {
+		return
+	}
+	if config.Firewall != nil {
+		tracker.OnBan(mirrorBan(config.Firewall))
+	}
+	tracker.OnBan(replicator.Record)
+}
+
+// logBan writes the bans and unbans of the tracker to the event log
+func logBan(events *eventlog.Logger) func(ip.BanEvent) {
+	return func(event ip.BanEvent) {
+		if event.Unban {
+			events.Unban(event.Target, event.Kind)
+		} else {
+			events.Ban(event.Target, event.Kind, event.Ban.Reason, event.Ban.Until)
+		}
+	}
+}
+
+func saveState(tracker *ip.IPTracker, path string) {
+	if err := tracker.SaveState(path); err != nil {
+		log.Printf("Failed to save state to %s: %v", path, err)
+	}
+}
+
+// saveStatePeriodically saves the state of the tracker every interval until ctx is done
+func saveStatePeriodically(tracker *ip.IPTracker, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			saveState(tracker, path)
+		}
+	}
+}
+
+func serve(backendURL *url.URL, config ServeConfig) {
+	globalAdminPassword = config.AdminPassword
 	defer wg.Done()
 
 	ringBuffer := lastrequests.NewRingBuffer(50)
 
-	tracker := ip.NewIPTracker(hit404threshold, time.Duration(banDurantionInMinutes)*time.Minute) // Ban after x 404s, ban lasts 1 minute
+	tracker := ip.NewIPTracker(config.Hit404Threshold, time.Duration(config.Hit404WindowInMinutes)*time.Minute, time.Duration(config.BanDurationInMinutes)*time.Minute) // Ban after x 404s in the window, ban lasts 1 minute
+	tracker.SetBanEscalation(config.BanEscalation, config.BanMaxDuration, config.BanHistoryTTL)
+	tracker.SetShadowThreshold(config.ShadowThreshold)
+	tracker.SetAccessLists(config.AllowList, config.DenyList)
+	tracker.SetSubnetAggregation(config.SubnetV4Prefix, config.SubnetV6Prefix, config.SubnetThreshold)
+	if config.Hosting != nil {
+		tracker.SetHosting(config.Hosting.Provider, config.HostingThreshold)
+	}
+	if config.GeoIP != nil {
+		tracker.SetGeoIP(config.GeoIP.Lookup)
+	}
+
+	replicator, err := peers.New(config.NodeName, config.Peers, config.AdminPassword, tracker)
+	if err != nil {
+		log.Fatalf("Failed to configure the peers: %v", err)
+	}
+	watchBans(tracker, replicator, config)
+	go replicator.Run(ctx, config.PeerSyncInterval)
+	go tracker.Janitor(ctx, config.CleanupInterval, config.PruneAfter, config.MaxTrackedIPs)
+
+	if config.StateFile != "" {
+		if err := tracker.LoadState(config.StateFile); err != nil {
+			log.Printf("Failed to load state from %s, starting empty: %v", config.StateFile, err)
+		}
+		go saveStatePeriodically(tracker, config.StateFile, config.StateSaveInterval)
+		onShutdown(func() { saveState(tracker, config.StateFile) })
+	}
+
+	clientIPResolver := &ClientIPResolver{TrustedProxies: config.TrustedProxies, Header: config.ClientIPHeader, Forwarded: config.ForwardedHeader}
+
+	ipLimiter := ratelimit.NewLimiter(config.RateLimit, config.RateBurst)
+	go ipLimiter.Janitor(ctx, time.Minute)
+	routeLimiters := ratelimit.NewRouteLimiters(config.RouteLimits)
+	go routeLimiters.Janitor(ctx, time.Minute)
+	ipConnections := active.NewIPConnections(config.MaxConcurrentPerIP, config.ConcurrencyQueue)
+	backendBudget := backendcost.NewBudget(config.BackendBudgetWindow, config.BackendBudgetShare, config.BackendMinSeconds)
+	go backendBudget.Janitor(ctx, time.Minute)
 
 	// these one where not bad, should perhaps be aligned
 	// https://github.com/stevensouza/jamonapi/blob/4a5f2dd43fd276271c92b54f1c66eeb83366ad0a/jamon/src/main/java/com/jamonapi/RangeHolder.java#L53-L65
@@ -73,44 +278,138 @@ func serve(backendURL *url.URL, disableBan bool, hit404threshold int, banDuranti
 	bucketStats := buckets.NewBucketStats(bucketsDef)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		client_ip := r.Header.Get("X-Forwarded-For")
-		if client_ip == "" {
-			client_ip, _, _ = net.SplitHostPort(r.RemoteAddr) // Extract the IP without the port
-		} else {
-			// apparently X-Forwarded-For: <client>, <reverse_proxy>
-			client_ip = strings.TrimSpace(strings.Split(client_ip, ",")[0])
-		}
+		client_ip := clientIPResolver.ClientIP(r)
 
 		start := time.Now()
 		hits := tracker.GetHits(client_ip)
+		provider := config.Hosting.Provider(client_ip)
+		geo := config.GeoIP.Lookup(client_ip)
+		ruleRequest := rules.Request{Path: r.URL.Path, Provider: provider, Country: geo.Country, ASN: geo.ASN}
 
 		log.Printf("Access log: method=%s url=%s ip=%s hits=%d", r.Method, r.URL.String(), client_ip, hits)
 
-		if !disableBan && tracker.CheckBan(client_ip) {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			log.Printf("Access log: method=%s url=%s ip=%s hits=%d (blocked)", r.Method, r.URL.String(), client_ip, hits)
+		if ban, banned := tracker.BanOf(client_ip); banned && !config.DisableBan {
+			info := newBlockInfo(client_ip, ban.Reason, ban.Until)
+			response := refuse(w, r, config.Tarpit, config.BlockPage, responseFor(config.Rules, ban.Reason, config.BannedResponse), info)
+			log.Printf("Access log: method=%s url=%s ip=%s hits=%d (blocked, %s, ref=%s)", r.Method, r.URL.String(), client_ip, hits, response, info.Reference)
 			return
 		}
-		if modifyHost {
-			r.Host = backendURL.Host
+		if config.Honeypot.IsTrap(r.URL.Path) {
+			// never forward a trap to the backend, answer like a missing page
+			tracker.Ban(client_ip, ip.ReasonHoneypot)
+			http.NotFound(w, r)
+			ringBuffer.Add(lastrequests.RequestInfo{
+				FullURL:    fmt.Sprintf("%s://%s%s", getScheme(r), r.Host, r.URL.RequestURI()),
+				StatusCode: http.StatusNotFound,
+				UserAgent:  r.Header.Get("User-Agent"),
+				StartTime:  start,
+				Duration:   time.Since(start).Seconds(),
+				Ip:         client_ip,
+				Reason:     ip.ReasonHoneypot,
+				Hosting:    provider,
+				Geo:        geo,
+			})
+			log.Printf("Access log: method=%s url=%s ip=%s hits=%d (honeypot)", r.Method, r.URL.String(), client_ip, hits)
+			return
+		}
+
+		if matched := config.Rules.Match(ruleRequest); len(matched) > 0 {
+			blocked := applyRules(tracker, config.EventLog, matched, client_ip, r)
+			ban, banned := tracker.BanOf(client_ip)
+			if blocked != nil {
+				ban, banned = ip.Ban{Reason: "rule:" + blocked.Name}, true
+			}
+			if banned && !config.DisableBan {
+				info := newBlockInfo(client_ip, ban.Reason, ban.Until)
+				response := refuse(w, r, config.Tarpit, config.BlockPage, responseFor(config.Rules, ban.Reason, config.BannedResponse), info)
+				log.Printf("Access log: method=%s url=%s ip=%s hits=%d (blocked by rule, %s, ref=%s)", r.Method, r.URL.String(), client_ip, hits, response, info.Reference)
+				return
+			}
+		}
 
+		if config.Challenger != nil && !config.DisableBan && tracker.GetScore(client_ip) >= config.ChallengeThreshold && !config.Challenger.HasPass(r, client_ip) {
+			tracker.AddScore(client_ip, config.ChallengeWeight)
+			redirectToChallenge(w, r)
+			log.Printf("Access log: method=%s url=%s ip=%s hits=%d (challenged)", r.Method, r.URL.String(), client_ip, hits)
+			return
 		}
 
 		cleanedPath := CleanPath(r.URL.Path)
 
+		allowed, retryAfter := ipLimiter.Allow(client_ip)
+		if allowed {
+			allowed, retryAfter = routeLimiters.Allow(cleanedPath, client_ip)
+			if !allowed {
+				// refused by the route, the request doesn't count against the ip
+				ipLimiter.Refund(client_ip)
+			}
+		}
+		if !allowed {
+			tracker.IncrementStatus(client_ip, http.StatusTooManyRequests)
+			if config.RateLimitBanWeight > 0 {
+				tracker.AddScore(client_ip, config.RateLimitBanWeight)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			log.Printf("Access log: method=%s url=%s ip=%s hits=%d (rate limited)", r.Method, r.URL.String(), client_ip, hits)
+			return
+		}
+
+		if over, retryAfter := backendBudget.OverBudget(client_ip); over && !config.DisableBan {
+			if config.BackendBudgetBan {
+				tracker.Ban(client_ip, backendcost.Reason)
+				// the ban is the penalty, don't ban again for the same usage once it expires
+				backendBudget.Reset(client_ip)
+				if ban, banned := tracker.BanOf(client_ip); banned {
+					info := newBlockInfo(client_ip, ban.Reason, ban.Until)
+					response := refuse(w, r, config.Tarpit, config.BlockPage, responseFor(config.Rules, ban.Reason, config.BannedResponse), info)
+					log.Printf("Access log: method=%s url=%s ip=%s hits=%d (over backend budget, %s, ref=%s)", r.Method, r.URL.String(), client_ip, hits, response, info.Reference)
+					return
+				}
+			} else {
+				tracker.IncrementStatus(client_ip, http.StatusTooManyRequests)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				log.Printf("Access log: method=%s url=%s ip=%s hits=%d (over backend budget)", r.Method, r.URL.String(), client_ip, hits)
+				return
+			}
+		}
+
+		if config.ModifyHost {
+			r.Host = backendURL.Host
+
+		}
+
+		release, acquired := ipConnections.Acquire(r.Context(), client_ip)
+		if !acquired {
+			tracker.IncrementStatus(client_ip, http.StatusTooManyRequests)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			log.Printf("Access log: method=%s url=%s ip=%s hits=%d (too many concurrent requests)", r.Method, r.URL.String(), client_ip, hits)
+			return
+		}
+		defer release()
+
 		connStats := active.RecordActiveConnection(cleanedPath)
 		defer func() {
 			connStats.StopActiveConnection()
 		}()
+		// only the time spent in the backend is charged, not the queue or the checks above
+		var backendStart time.Time
 		reverseProxy := httputil.NewSingleHostReverseProxy(backendURL)
 		reverseProxy.ModifyResponse = func(resp *http.Response) error {
-			if resp.StatusCode == http.StatusNotFound {
-				tracker.IncrementHit(client_ip)
+			if weight := config.StatusWeights[resp.StatusCode]; weight > 0 {
+				tracker.AddScore(client_ip, weight)
+				config.EventLog.Status(client_ip, resp.StatusCode, r.URL.Path)
 			}
+			responseRule := ruleRequest
+			responseRule.Status = resp.StatusCode
+			applyRules(tracker, config.EventLog, config.Rules.Match(responseRule), client_ip, r)
 			tracker.IncrementStatus(client_ip, resp.StatusCode)
 
-			hits := tracker.GetHits(client_ip)
+			score := tracker.GetScore(client_ip)
 			duration := time.Since(start).Seconds()
+			backendBudget.Record(client_ip, time.Since(backendStart).Seconds())
 
 			stats := perPathStats.GetStatsForPath(cleanedPath)
 			stats.Record(duration, resp.StatusCode)
@@ -123,19 +422,36 @@ func serve(backendURL *url.URL, disableBan bool, hit404threshold int, banDuranti
 				StartTime:  start,
 				Duration:   duration,
 				Ip:         client_ip,
+				Hosting:    provider,
+				Geo:        geo,
 			}
 
 			ringBuffer.Add(request)
 
 			bucketStats.Record(duration, resp.StatusCode)
 
-			log.Printf("Access log: method=%s url=%s ip=%s hits=%d status=%v duration=%.3f", r.Method, r.URL.String(), client_ip, hits, resp.StatusCode, duration)
+			log.Printf("Access log: method=%s url=%s ip=%s score=%.1f status=%v duration=%.3f", r.Method, r.URL.String(), client_ip, score, resp.StatusCode, duration)
 
 			return nil
 		}
+		reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			// errors and timeouts are often the most expensive requests, charge them too
+			backendBudget.Record(client_ip, time.Since(backendStart).Seconds())
+			log.Printf("http: proxy error: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		backendStart = time.Now()
 		reverseProxy.ServeHTTP(w, r)
 	})
 
+	if config.HoneypotRobots {
+		robotsTxt := config.Honeypot.RobotsTxt()
+		http.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(robotsTxt))
+		})
+	}
+
 	http.Handle("/__banme/api/info", AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		info := tracker.GetTrackerInfo()
 		info["percentiles.buckets"] = bucketStats.Buckets()
@@ -157,8 +473,21 @@ func serve(backendURL *url.URL, disableBan bool, hit404threshold int, banDuranti
 			connnStats := active.GetActiveConnections(path)
 			stats["active"] = connnStats.GetActiveConnections()
 			stats["maxActive"] = connnStats.GetMaxActiveConnections()
+			if limits := routeLimiters.Info(path); limits != nil {
+				stats["rateLimit"] = limits
+			}
 		}
 
+		info["rateLimit"] = ipLimiter.Info()
+		info["replication"] = replicator.Info()
+		info["backendTime"] = backendBudget.Top(50)
+		info["activeByIp"] = ipConnections.GetActiveConnections()
+		info["system.concurrencyQueued"], info["system.concurrencyRejected"] = ipConnections.Stats()
+		info["system.backendSecondsInWindow"], info["system.backendOverBudget"] = backendBudget.Info()
+		tarpitInfo := config.Tarpit.Info()
+		info["system.tarpitHeld"] = tarpitInfo["held"]
+		info["system.tarpitTotal"] = tarpitInfo["total"]
+		info["system.tarpitOverflow"] = tarpitInfo["overflow"]
 		info["percentiles.statusCount"] = bucketStats.StatusesCount
 		info["lastRequests"] = ringBuffer.GetAll()
 		w.Header().Set("Content-Type", "application/json")
@@ -185,11 +514,15 @@ func serve(backendURL *url.URL, disableBan bool, hit404threshold int, banDuranti
 		}
 	})))
 
-	http.Handle("/__banme/api/unban", AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tracker.UnbanAll()
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("All IPs have been unbanned."))
-	})))
+	if config.Challenger != nil {
+		// public on purpose: the clients to challenge don't have the admin password
+		http.Handle(challengePath, challengePageHandler(config.Challenger, clientIPResolver))
+		http.Handle(challengeVerifyPath, challengeVerifyHandler(config.Challenger, tracker, clientIPResolver))
+	}
+	http.Handle(peers.EventsPath, AuthMiddleware(replicator.Handler()))
+	http.Handle("/__banme/api/unban", AuthMiddleware(unbanHandler(tracker)))
+	http.Handle("/__banme/api/bans", AuthMiddleware(bansHandler(tracker)))
+	http.Handle("/__banme/api/reset", AuthMiddleware(resetHandler(tracker)))
 
 	isDev := os.Getenv("DEV_MODE") == "true"
 
@@ -211,8 +544,26 @@ func serve(backendURL *url.URL, disableBan bool, hit404threshold int, banDuranti
 
 	http.Handle("/__banme/", AuthMiddleware(fsHandler))
 
-	log.Printf("Reverse proxy is running on :8000 for %s, hit404threshold=%v, banDurantionInMinutes=%v", backendURL, hit404threshold, banDurantionInMinutes)
-	if err := http.ListenAndServe(":8000", nil); err != nil {
+	for _, limit := range config.RouteLimits {
+		log.Printf("Route limit %s perIp=%+v global=%+v", limit.Path, limit.PerIP, limit.Global)
+	}
+	for _, trap := range config.Honeypot.Paths() {
+		log.Printf("Honeypot %s", trap)
+	}
+	for _, rule := range config.Rules.Rules() {
+		log.Printf("Rule %s (pack %q) glob=%q regex=%q action=%s weight=%v", rule.Name, rule.Pack, rule.Glob, rule.Regex, rule.Action, rule.Weight)
+	}
+
+	log.Printf("Reverse proxy is running on %s for %s, hit404threshold=%v, hit404WindowInMinutes=%v, statusWeights=%v, banDurantionInMinutes=%v, banEscalation=%v, banMaxDuration=%v", config.Listen, backendURL, config.Hit404Threshold, config.Hit404WindowInMinutes, config.StatusWeights, config.BanDurationInMinutes, config.BanEscalation, config.BanMaxDuration)
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	if config.ProxyProtocol {
+		listener = proxyproto.NewListener(listener, config.ProxyProtocolTrusted)
+		log.Printf("Accepting PROXY protocol headers from %d trusted ranges", config.ProxyProtocolTrusted.Len())
+	}
+	if err := http.Serve(listener, nil); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -0,0 +1,192 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"reverseproxy/ipranges"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature starts every PROXY protocol v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLength is the longest possible v1 header including the CRLF
+const v1MaxLength = 107
+
+// Listener wraps a listener to read the HAProxy PROXY protocol header (v1 or v2) sent by
+// load balancers, the source address of the header then becomes the RemoteAddr of the connection.
+// Headers are only honored on connections coming from the trusted ranges.
+type Listener struct {
+	net.Listener
+	Trusted           *ipranges.List
+	ReadHeaderTimeout time.Duration
+}
+
+// NewListener wraps the listener, only connections from trusted can announce their client address
+func NewListener(listener net.Listener, trusted *ipranges.List) *Listener {
+	return &Listener{Listener: listener, Trusted: trusted, ReadHeaderTimeout: 5 * time.Second}
+}
+
+// Accept waits for the next connection, the header is parsed on its first Read or RemoteAddr
+// so a slow client doesn't block the accept loop
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	trusted := false
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		trusted = l.Trusted.ContainsAddr(addr.AddrPort().Addr())
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), trusted: trusted, timeout: l.ReadHeaderTimeout}, nil
+}
+
+// Conn is a connection whose RemoteAddr comes from the PROXY protocol header if any
+type Conn struct {
+	net.Conn
+	reader     *bufio.Reader
+	trusted    bool
+	timeout    time.Duration
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if !c.trusted {
+			return
+		}
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.remoteAddr, c.err = readHeader(c.reader)
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// Read reads the data following the header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address of the header, or the one of the connection without header
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader consumes a v1 or v2 header, it returns a nil address when there is no header
+// or when it doesn't carry a source address (LOCAL command, UNKNOWN protocol)
+func readHeader(reader *bufio.Reader) (net.Addr, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := reader.Peek(6); err == nil && string(prefix) == "PROXY " {
+			return readV1(reader)
+		}
+	case '\r':
+		if prefix, err := reader.Peek(len(v2Signature)); err == nil && bytes.Equal(prefix, v2Signature) {
+			return readV2(reader)
+		}
+	}
+	return nil, nil
+}
+
+// readV1 parses "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy protocol v1: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol v1: header too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxy protocol v1: invalid header %q", strings.TrimSpace(string(line)))
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol v1: invalid source address: %w", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol v1: invalid source port: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), uint16(port))), nil
+}
+
+// readV2 parses the binary header: signature, version/command, family, length and addresses
+func readV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("proxy protocol v2: unsupported version %d", header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+	if command == 0 { // LOCAL, health checks of the load balancer itself
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, fmt.Errorf("proxy protocol v2: unsupported command %d", command)
+	}
+	switch family >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errors.New("proxy protocol v2: truncated ipv4 addresses")
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[8:10]))), nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errors.New("proxy protocol v2: truncated ipv6 addresses")
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[32:34]))), nil
+	}
+	// AF_UNSPEC or AF_UNIX, keep the address of the connection
+	return nil, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"reverseproxy/ipranges"
+	"reverseproxy/proxyproto"
+	"testing"
+)
+
+func v2Header(src net.IP, port uint16) []byte {
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x21, 0x11, 0, 12) // v2 PROXY, TCP over ipv4, 12 bytes of addresses
+	header = append(header, src.To4()...)
+	header = append(header, 127, 0, 0, 1)
+	header = binary.BigEndian.AppendUint16(header, port)
+	header = binary.BigEndian.AppendUint16(header, 8000)
+	return header
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	loopback, _ := ipranges.ParseList("127.0.0.0/8")
+	nobody, _ := ipranges.ParseList("192.0.2.0/24")
+
+	tests := []struct {
+		name         string
+		trusted      *ipranges.List
+		header       []byte
+		expectedAddr string
+	}{
+		{"v1 tcp4", loopback, []byte("PROXY TCP4 198.51.100.7 127.0.0.1 56324 8000\r\n"), "198.51.100.7:56324"},
+		{"v1 tcp6", loopback, []byte("PROXY TCP6 2001:db8::7 ::1 56324 8000\r\n"), "[2001:db8::7]:56324"},
+		{"v1 unknown", loopback, []byte("PROXY UNKNOWN\r\n"), "127.0.0.1"},
+		{"v2 tcp4", loopback, v2Header(net.ParseIP("203.0.113.9"), 4242), "203.0.113.9:4242"},
+		{"no header", loopback, nil, "127.0.0.1"},
+		{"untrusted source", nobody, nil, "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener := proxyproto.NewListener(inner, tt.trusted)
+			defer listener.Close()
+
+			go func() {
+				client, err := net.Dial("tcp", inner.Addr().String())
+				if err != nil {
+					return
+				}
+				defer client.Close()
+				client.Write(append(tt.header, []byte("GET / HTTP/1.1\r\n")...))
+			}()
+
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			remote := conn.RemoteAddr().String()
+			if tt.expectedAddr == "127.0.0.1" {
+				host, _, _ := net.SplitHostPort(remote)
+				remote = host
+			}
+			if remote != tt.expectedAddr {
+				t.Errorf("RemoteAddr() = %q, want %q", remote, tt.expectedAddr)
+			}
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || line != "GET / HTTP/1.1\r\n" {
+				t.Errorf("payload after the header = %q (%v), want the request line", line, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reverseproxy/trackers/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := ratelimit.NewLimiter(10, 3)
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+			t.Fatalf("request %d should be allowed by the burst", i+1)
+		}
+	}
+	allowed, retryAfter := limiter.Allow("10.0.0.1")
+	if allowed {
+		t.Fatalf("request above the burst should be rejected")
+	}
+	if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Errorf("retryAfter = %v, want at most the 100ms needed for a token at 10/s", retryAfter)
+	}
+	if allowed, _ := limiter.Allow("10.0.0.2"); !allowed {
+		t.Errorf("another ip should have its own bucket")
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+		t.Errorf("request should be allowed once a token refilled")
+	}
+
+	rejected := limiter.Info()["rejected"].(map[string]int)
+	if rejected["10.0.0.1"] != 1 {
+		t.Errorf("rejected = %v, want 1 for 10.0.0.1", rejected)
+	}
+
+	time.Sleep(320 * time.Millisecond)
+	limiter.Prune()
+	if rejected := limiter.Info()["rejected"].(map[string]int); len(rejected) != 0 {
+		t.Errorf("rejected = %v, want the counts dropped with their refilled bucket", rejected)
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	limiter := ratelimit.NewLimiter(0.01, 1)
+	limiter.Allow("10.0.0.1")
+	limiter.Refund("10.0.0.1")
+	if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+		t.Errorf("the refunded token should allow the next request")
+	}
+	limiter.Refund("10.0.0.1")
+	limiter.Refund("10.0.0.1")
+	if tokens := limiter.Bucket("10.0.0.1").Tokens(); tokens > 1 {
+		t.Errorf("Tokens() = %v, refunds should not go over the burst", tokens)
+	}
+}
+
+func TestDisabledLimiter(t *testing.T) {
+	var limiter = ratelimit.NewLimiter(0, 10)
+	if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+		t.Errorf("a disabled limiter should allow everything")
+	}
+}
+
+func TestRouteLimiters(t *testing.T) {
+	routes := ratelimit.NewRouteLimiters([]ratelimit.RouteLimit{
+		{Path: "/api/{id}/export.pdf", PerIP: &ratelimit.Limit{Rate: 0.01, Burst: 1}, Global: &ratelimit.Limit{Rate: 0.01, Burst: 2}},
+	})
+
+	if allowed, _ := routes.Allow("/api/{id}/other", "10.0.0.1"); !allowed {
+		t.Errorf("a path without limits should be allowed")
+	}
+	if allowed, _ := routes.Allow("/api/{id}/export.pdf", "10.0.0.1"); !allowed {
+		t.Errorf("first export of 10.0.0.1 should be allowed")
+	}
+	if allowed, _ := routes.Allow("/api/{id}/export.pdf", "10.0.0.1"); allowed {
+		t.Errorf("second export of 10.0.0.1 should hit the per ip limit")
+	}
+	if allowed, _ := routes.Allow("/api/{id}/export.pdf", "10.0.0.2"); !allowed {
+		t.Errorf("first export of 10.0.0.2 should be allowed")
+	}
+	if allowed, _ := routes.Allow("/api/{id}/export.pdf", "10.0.0.3"); allowed {
+		t.Errorf("third export overall should hit the global limit")
+	}
+	// the per ip token taken before the global limit refused is given back
+	tokens := routes.Info("/api/{id}/export.pdf")["perIpTokens"].(map[string]float64)
+	if tokens["10.0.0.3"] < 1 {
+		t.Errorf("per ip tokens of 10.0.0.3 = %v, want its bucket untouched by the global rejection", tokens["10.0.0.3"])
+	}
+
+	info := routes.Info("/api/{id}/export.pdf")
+	if info["rejected"].(int64) != 2 {
+		t.Errorf("rejected = %v, want 2", info["rejected"])
+	}
+	if routes.Info("/api/{id}/other") != nil {
+		t.Errorf("Info() of a path without limits should be nil")
+	}
+}
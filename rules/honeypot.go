@@ -0,0 +1,56 @@
+package rules
+
+import (
+	"strings"
+)
+
+// Honeypot holds trap paths no legitimate client should ever request
+type Honeypot struct {
+	paths []string
+}
+
+// NewHoneypot creates a honeypot for the comma separated paths
+func NewHoneypot(paths string) *Honeypot {
+	honeypot := &Honeypot{}
+	for _, path := range strings.Split(paths, ",") {
+		// an empty trap (like "/") would match every path and ban every client
+		path = strings.Trim(strings.TrimSpace(path), "/")
+		if path == "" {
+			continue
+		}
+		honeypot.paths = append(honeypot.paths, "/"+path)
+	}
+	return honeypot
+}
+
+// IsTrap reports if the path is a trap path or below one
+func (h *Honeypot) IsTrap(path string) bool {
+	if h == nil {
+		return false
+	}
+	for _, trap := range h.paths {
+		if strings.EqualFold(path, trap) || strings.HasPrefix(strings.ToLower(path), strings.ToLower(trap)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Paths returns the trap paths
+func (h *Honeypot) Paths() []string {
+	if h == nil {
+		return nil
+	}
+	return h.paths
+}
+
+// RobotsTxt generates a robots.txt disallowing the trap paths, well behaved crawlers
+// will skip them while scanners often use it as a list of interesting paths
+func (h *Honeypot) RobotsTxt() string {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, trap := range h.Paths() {
+		b.WriteString("Disallow: " + trap + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// packs are the built-in rules for paths a node backend never serves
+var packs = map[string][]Rule{
+	"php": {
+		{Name: "php-script", Regex: `(?i)\.(php[0-9]?|phtml)$`, Action: ActionBan},
+		{Name: "phpmyadmin", Regex: `(?i)/(phpmyadmin|pma)`, Action: ActionBan},
+		{Name: "phpunit", Glob: "**/vendor/phpunit/**", Action: ActionBan},
+	},
+	"java": {
+		{Name: "spring-actuator", Regex: `(?i)/actuator(/|$)`, Action: ActionBan},
+		{Name: "java-servlet", Regex: `(?i)\.(jsp|jspx|do|action)$`, Action: ActionBan},
+		{Name: "web-inf", Glob: "**/WEB-INF/**", Action: ActionBan},
+		{Name: "tomcat-manager", Glob: "**/manager/html*", Action: ActionBan},
+		{Name: "jmx-console", Glob: "**/jmx-console/**", Action: ActionBan},
+	},
+	"secrets": {
+		{Name: "dotenv", Glob: "**/.env*", Action: ActionBan},
+		{Name: "git", Regex: `(?i)/\.git(/|$)`, Action: ActionBan},
+		{Name: "svn", Regex: `(?i)/\.svn(/|$)`, Action: ActionBan},
+		{Name: "aws-credentials", Glob: "**/.aws/**", Action: ActionBan},
+		{Name: "ssh-keys", Regex: `(?i)/(\.ssh/|id_rsa|id_ed25519)`, Action: ActionBan},
+		{Name: "htpasswd", Glob: "**/.ht*", Action: ActionBan},
+		{Name: "ds-store", Glob: "**/.DS_Store", Action: ActionScore, Weight: 5},
+		{Name: "backup-files", Regex: `(?i)\.(sql|bak|old|swp|tar\.gz|zip)$`, Action: ActionScore, Weight: 5},
+	},
+	"wordpress": {
+		{Name: "wp-login", Glob: "**/wp-login.php", Action: ActionBan},
+		{Name: "wp-admin", Regex: `(?i)/wp-admin(/|$)`, Action: ActionBan},
+		{Name: "wp-content", Glob: "**/wp-content/**", Action: ActionBan},
+		{Name: "wp-includes", Glob: "**/wp-includes/**", Action: ActionBan},
+		{Name: "xmlrpc", Glob: "**/xmlrpc.php", Action: ActionBan},
+	},
+}
+
+// PackNames returns the names of the built-in rule packs
+func PackNames() []string {
+	names := make([]string, 0, len(packs))
+	for name := range packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Packs returns the rules of the comma separated built-in packs
+func Packs(names string) ([]Rule, error) {
+	var rules []Rule
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		pack, exists := packs[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown rule pack %q, available packs are %s", name, strings.Join(PackNames(), ","))
+		}
+		for _, rule := range pack {
+			rule.Pack = name
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,249 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Action is what happens to an ip requesting a path matching a rule
+type Action string
+
+const (
+	// ActionBan bans the ip on the first hit
+	ActionBan Action = "ban"
+	// ActionScore adds the weight of the rule to the score of the ip
+	ActionScore Action = "score"
+	// ActionBlock refuses the request without banning the ip
+	ActionBlock Action = "block"
+)
+
+// Response is how banned or blocked clients are answered
+type Response string
+
+const (
+	// ResponseForbidden answers right away with a 403
+	ResponseForbidden Response = "forbidden"
+	// ResponseTarpit keeps the connection open and drips the response out byte by byte
+	ResponseTarpit Response = "tarpit"
+	// ResponseDelay waits before answering with a 403
+	ResponseDelay Response = "delay"
+)
+
+// ParseResponse validates a response name, "" is the forbidden response
+func ParseResponse(name string) (Response, error) {
+	switch response := Response(name); response {
+	case "":
+		return ResponseForbidden, nil
+	case ResponseForbidden, ResponseTarpit, ResponseDelay:
+		return response, nil
+	}
+	return "", fmt.Errorf("unknown response %q, expected %s, %s or %s", name, ResponseForbidden, ResponseTarpit, ResponseDelay)
+}
+
+// Request holds what the rules can match on
+type Request struct {
+	Path string
+	// Status is the status of the backend response, 0 while the request is not proxied yet
+	Status int
+	// Provider is the hosting provider of the client ip, "" for non datacenter traffic
+	Provider string
+	Country  string
+	ASN      uint
+}
+
+// Rule matches requests on their path with either a glob or a regex, and optionally on
+// the response status or what we know about the client ip.
+//
+// Globs match the whole path: "*" matches within a path segment, "**" across segments
+// and "?" a single character, they are case insensitive. Regexes match anywhere in the path
+// unless anchored. A rule without glob nor regex matches every path.
+//
+// Hosting restricts the rule to datacenter traffic, Providers to some hosting providers,
+// Countries (ISO codes) and ASNs to some client origins. Rules with Statuses are evaluated on
+// the backend response (ex: ban on the first 404 from an ASN), the others before proxying. As
+// the response is already sent then, they can ban or score but not block.
+//
+// Shadow rules are matched but not enforced, their decisions are only recorded to be reviewed.
+type Rule struct {
+	Name      string   `json:"name"`
+	Pack      string   `json:"pack,omitempty"`
+	Glob      string   `json:"glob,omitempty"`
+	Regex     string   `json:"regex,omitempty"`
+	Action    Action   `json:"action"`
+	Weight    float64  `json:"weight,omitempty"`
+	Hosting   bool     `json:"hosting,omitempty"`
+	Providers []string `json:"providers,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	ASNs      []uint   `json:"asns,omitempty"`
+	Statuses  []int    `json:"statuses,omitempty"`
+	Shadow    bool     `json:"shadow,omitempty"`
+	// Response overrides the default response for the clients banned or blocked by the rule
+	Response Response `json:"response,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the rule and prepares its regular expression
+func (r *Rule) compile() error {
+	if r.Glob != "" && r.Regex != "" {
+		return fmt.Errorf("rule %q: glob and regex are exclusive", r.Name)
+	}
+	if r.Glob == "" && r.Regex == "" && !r.Hosting && len(r.Providers) == 0 && len(r.Countries) == 0 && len(r.ASNs) == 0 && len(r.Statuses) == 0 {
+		return fmt.Errorf("rule %q: a glob, a regex or another condition is required", r.Name)
+	}
+	switch r.Action {
+	case ActionBan:
+	case ActionBlock:
+		if len(r.Statuses) > 0 {
+			// the response is already on its way when the status is known, use ban instead
+			return fmt.Errorf("rule %q: block can't apply to a backend response status", r.Name)
+		}
+	case ActionScore:
+		if r.Weight <= 0 {
+			return fmt.Errorf("rule %q: score rules need a positive weight", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown action %q", r.Name, r.Action)
+	}
+	if r.Response != "" {
+		if _, err := ParseResponse(string(r.Response)); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	pattern := r.Regex
+	if r.Glob != "" {
+		pattern = GlobToRegex(r.Glob)
+	}
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	r.re = re
+	return nil
+}
+
+// Matches reports if the request triggers the rule
+func (r *Rule) Matches(request Request) bool {
+	if (r.Hosting || len(r.Providers) > 0) && request.Provider == "" {
+		return false
+	}
+	if len(r.Providers) > 0 && !slices.Contains(r.Providers, request.Provider) {
+		return false
+	}
+	if len(r.Countries) > 0 && !slices.ContainsFunc(r.Countries, func(country string) bool { return strings.EqualFold(country, request.Country) }) {
+		return false
+	}
+	if len(r.ASNs) > 0 && !slices.Contains(r.ASNs, request.ASN) {
+		return false
+	}
+	// status rules only apply to responses, the others only to requests
+	if len(r.Statuses) > 0 {
+		if !slices.Contains(r.Statuses, request.Status) {
+			return false
+		}
+	} else if request.Status != 0 {
+		return false
+	}
+	return r.re == nil || r.re.MatchString(request.Path)
+}
+
+// GlobToRegex converts a path glob to an anchored case insensitive regular expression
+func GlobToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Engine evaluates the configured rules against request paths
+type Engine struct {
+	rules []*Rule
+}
+
+// NewEngine compiles the rules, failing on the first invalid one
+func NewEngine(rules []Rule) (*Engine, error) {
+	engine := &Engine{}
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		engine.rules = append(engine.rules, &rule)
+	}
+	return engine, nil
+}
+
+// Match returns the rules matching the request, in their declaration order
+func (e *Engine) Match(request Request) []*Rule {
+	if e == nil {
+		return nil
+	}
+	var matched []*Rule
+	for _, rule := range e.rules {
+		if rule.Matches(request) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+// Find returns the rule with that name, nil when unknown
+func (e *Engine) Find(name string) *Rule {
+	if e == nil {
+		return nil
+	}
+	for _, rule := range e.rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Rules returns the rules of the engine
+func (e *Engine) Rules() []*Rule {
+	if e == nil {
+		return nil
+	}
+	return e.rules
+}
+
+// LoadFile reads a json array of rules
+func LoadFile(path string) ([]Rule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
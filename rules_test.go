@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reverseproxy/rules"
+	"reverseproxy/trackers/ip"
+	"testing"
+	"time"
+)
+
+func TestRulePacks(t *testing.T) {
+	enabled, err := rules.Packs("php,java,secrets,wordpress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := rules.NewEngine(enabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/wp-login.php", "php-script"},
+		{"/blog/WP-LOGIN.PHP", "php-script"},
+		{"/.env", "dotenv"},
+		{"/api/.env.production", "dotenv"},
+		{"/.git/config", "git"},
+		{"/actuator/env", "spring-actuator"},
+		{"/backup/db.sql", "backup-files"},
+		{"/api/forms/456.json", ""},
+		{"/environment", ""},
+		{"/static/app.js", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			matched := engine.Match(rules.Request{Path: tt.path})
+			got := ""
+			if len(matched) > 0 {
+				got = matched[0].Name
+			}
+			if got != tt.expected {
+				t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob     string
+		path     string
+		expected bool
+	}{
+		{"**/*.php", "/index.php", true},
+		{"**/*.php", "/a/b/index.php", true},
+		{"/admin/*", "/admin/users", true},
+		{"/admin/*", "/admin/users/1", false},
+		{"/admin/**", "/admin/users/1", true},
+		{"/file?.txt", "/file1.txt", true},
+		{"/file?.txt", "/file/.txt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			engine, err := rules.NewEngine([]rules.Rule{{Name: "test", Glob: tt.glob, Action: rules.ActionBan}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(engine.Match(rules.Request{Path: tt.path})) > 0; got != tt.expected {
+				t.Errorf("glob %q on %q = %v, want %v", tt.glob, tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHoneypot(t *testing.T) {
+	honeypot := rules.NewHoneypot("/old-admin/, backup-2019, /, //")
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"/old-admin", true},
+		{"/old-admin/login", true},
+		{"/OLD-ADMIN", true},
+		{"/backup-2019", true},
+		{"/old-administrator", false},
+		{"/", false},
+		{"/index.html", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := honeypot.IsTrap(tt.path); got != tt.expected {
+				t.Errorf("IsTrap(%q) = %v, want %v", tt.path, got, tt.expected)
+			}
+		})
+	}
+
+	expectedRobots := "User-agent: *\nDisallow: /old-admin\nDisallow: /backup-2019\n"
+	if got := honeypot.RobotsTxt(); got != expectedRobots {
+		t.Errorf("RobotsTxt() = %q, want %q", got, expectedRobots)
+	}
+}
+
+func TestShadowRules(t *testing.T) {
+	engine, err := loadRules("php", "", "php-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRules("php", "", "missing"); err == nil {
+		t.Errorf("loadRules() with an unknown shadow rule should fail")
+	}
+	tracker := ip.NewIPTracker(5, time.Minute, time.Minute)
+	request := httptest.NewRequest("GET", "/index.php", nil)
+
+	matched := engine.Match(rules.Request{Path: request.URL.Path})
+	if len(matched) == 0 {
+		t.Fatalf("php pack should match %s", request.URL.Path)
+	}
+	if blocked := applyRules(tracker, nil, matched, "192.0.2.1", request); blocked != nil {
+		t.Errorf("applyRules() = true, shadow rules never block")
+	}
+	if tracker.CheckBan("192.0.2.1") {
+		t.Errorf("shadow rule should not ban")
+	}
+	decisions := tracker.GetTrackerInfo()["shadow"].([]ip.ShadowDecision)
+	if len(decisions) != 1 || decisions[0].IP != "192.0.2.1" || decisions[0].Reason != "rule:php-script" || decisions[0].Action != "ban" {
+		t.Errorf("shadow decisions = %+v", decisions)
+	}
+}
+
+func TestShadowThreshold(t *testing.T) {
+	tracker := ip.NewIPTracker(10, time.Minute, time.Minute)
+	tracker.SetShadowThreshold(2)
+
+	for i := 0; i < 5; i++ {
+		tracker.IncrementHit("192.0.2.1")
+	}
+	if tracker.CheckBan("192.0.2.1") {
+		t.Errorf("shadow threshold should not ban")
+	}
+	decisions := tracker.GetTrackerInfo()["shadow"].([]ip.ShadowDecision)
+	if len(decisions) != 1 || decisions[0].Reason != ip.ReasonThreshold {
+		t.Errorf("shadow decisions = %+v, want a single threshold decision", decisions)
+	}
+}
@@ -0,0 +1,36 @@
+#!/bin/bash
+# Starts 3 banme instances sharing their bans on :8001, :8002 and :8003 in front of a python backend on :8080
+# then bans an ip on the first one and shows it on the others.
+
+kill -9 $(ps aux | grep '[p]ython3 -m http.server 8080' | awk '{print $2}') 2>/dev/null
+kill -9 $(ps aux | grep './reverse_proxy' | grep -v grep | awk '{print $2}') 2>/dev/null
+
+set -e  # Exit the script if any command fails
+
+go build -o reverse_proxy .
+
+python3 -m http.server 8080 > /dev/null 2>&1 &
+
+export BANME_ADMIN_PASSWORD=secretsauce
+export BANME_BACKEND_URL=http://localhost:8080
+for port in 8001 8002 8003; do
+  peers=""
+  for other in 8001 8002 8003; do
+    if [ "$other" != "$port" ]; then
+      peers="$peers,http://127.0.0.1:$other"
+    fi
+  done
+  ./reverse_proxy -listen :$port -node-name node-$port -peers "${peers#,}" -peer-sync-interval 2s sleep infinity > /tmp/banme-$port.log 2>&1 &
+done
+
+for port in 8001 8002 8003; do
+  until curl -s -o /dev/null http://127.0.0.1:$port/; do sleep 0.5; done
+done
+curl -s -u admin:$BANME_ADMIN_PASSWORD -X POST -d '{"target": "192.0.2.1", "duration": "1h", "comment": "replication test"}' http://127.0.0.1:8001/__banme/api/bans
+echo
+sleep 1
+for port in 8002 8003; do
+  echo "bans on :$port"
+  curl -s -u admin:$BANME_ADMIN_PASSWORD http://127.0.0.1:$port/__banme/api/bans
+  echo
+done
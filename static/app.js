@@ -36,6 +36,7 @@ function populateFilterableTable(table, paths, bucketTimes) {
     .concat(bucketTimes.map((t) => "<th> R " + t + "</th>"))
     .concat([
       "<th>Statuses</th>",
+      "<th>Rate limit tokens</th>",
       "<th>First seen</th>",
       "<th>Last seen</th>",
       "</tr></thead>",
@@ -67,6 +68,7 @@ function populateFilterableTable(table, paths, bucketTimes) {
       )
       .concat([
         `<td>${JSON.stringify(stats["statusCount"])}</td>`,
+        `<td>${formatRateLimit(stats["rateLimit"])}</td>`,
         `<td>${stats["firstSeen"]}</td>`,
         `<td>${stats["lastSeen"]}</td>`,
       ])
@@ -74,6 +76,108 @@ function populateFilterableTable(table, paths, bucketTimes) {
   }
 }
 
+// Populate the banned ips (or subnets) with their offense count and ban end
+function populateBannedTable(table, banned, title) {
+  table.innerHTML = [
+    "<thead><tr>",
+    `<th>${title}</th>`,
+    "<th>Offenses</th>",
+    "<th>Reason</th>",
+    "<th>Since</th>",
+    "<th>Until</th>",
+    "</tr></thead>",
+  ].join("");
+  const tbody = table.appendChild(document.createElement("tbody"));
+  for (let ip of Object.keys(banned)) {
+    const ban = banned[ip];
+    const row = tbody.insertRow();
+    appendTextCells(row, [
+      ip,
+      ban["offenses"],
+      ban["reason"] +
+        (ban["comment"] ? " (" + ban["comment"] + ")" : "") +
+        (ban["origin"] ? " from " + ban["origin"] : ""),
+      ban["since"],
+      ban["until"],
+    ]);
+  }
+}
+
+// Append the values as text cells, they come from the clients or the peers so they
+// are never interpreted as html
+function appendTextCells(row, values) {
+  for (let value of values) {
+    row.insertCell().textContent = value;
+  }
+}
+
+// Populate the decisions of the rules and threshold running in shadow mode
+function populateShadowTable(table, decisions) {
+  table.innerHTML = [
+    "<thead><tr>",
+    "<th>Would have</th>",
+    "<th>Ip</th>",
+    "<th>Reason</th>",
+    "<th>Path</th>",
+    "<th>At</th>",
+    "</tr></thead>",
+  ].join("");
+  const tbody = table.appendChild(document.createElement("tbody"));
+  for (let decision of decisions) {
+    const row = tbody.insertRow();
+    appendTextCells(row, [
+      decision["action"],
+      decision["ip"],
+      decision["reason"],
+      decision["path"] || "",
+      decision["at"],
+    ]);
+  }
+}
+
+// Rank the ips by the backend time they used in the budget window
+function populateBackendTimeTable(table, usages) {
+  table.innerHTML = [
+    "<thead><tr>",
+    "<th>Ip</th>",
+    "<th>Backend seconds</th>",
+    "<th>Share</th>",
+    "<th>Requests</th>",
+    "</tr></thead>",
+  ].join("");
+  const tbody = table.appendChild(document.createElement("tbody"));
+  for (let usage of usages) {
+    const row = tbody.insertRow();
+    row.innerHTML = [
+      `<td>${usage["ip"]}</td>`,
+      `<td>${usage["seconds"].toFixed(3)}</td>`,
+      `<td>${(usage["share"] * 100).toFixed(1)}%</td>`,
+      `<td>${usage["requests"]}</td>`,
+    ].join("");
+  }
+}
+
+// Summarize the token levels of a rate limited path
+function formatRateLimit(rateLimit) {
+  if (rateLimit == undefined) {
+    return "";
+  }
+  const parts = [];
+  if (rateLimit["globalTokens"] != undefined) {
+    parts.push(
+      `global ${rateLimit["globalTokens"].toFixed(1)}/${rateLimit["globalBurst"]}`
+    );
+  }
+  if (rateLimit["perIpTokens"] != undefined) {
+    const ipTokens = Object.entries(rateLimit["perIpTokens"]).map(
+      ([ip, tokens]) => `${ip} ${tokens.toFixed(1)}/${rateLimit["perIpBurst"]}`
+    );
+    parts.push(...ipTokens);
+  }
+  parts.push(`rejected ${rateLimit["rejected"]}`);
+  return parts.join("<br/>");
+}
+
 // Filter the table based on search input
 function filterTable() {
   const searchValue = document
@@ -118,8 +222,21 @@ async function fetchAndDisplayInfo() {
     }
     const data = await response.json();
 
-    const infoBannedElement = document.getElementById("info-banned");
-    infoBannedElement.textContent = JSON.stringify(data.banned, null, 2);
+    populateBannedTable(
+      document.getElementById("info-banned"),
+      data.banned,
+      "Banned ip"
+    );
+    populateBannedTable(
+      document.getElementById("info-banned-subnets"),
+      data.bannedSubnets,
+      "Banned subnet"
+    );
+    populateShadowTable(document.getElementById("info-shadow"), data.shadow);
+    populateBackendTimeTable(
+      document.getElementById("info-backend-time"),
+      data.backendTime
+    );
 
     toTables("system.", document.getElementById("info-system"), data, []);
     toTables(
@@ -157,7 +274,9 @@ async function fetchAndDisplayInfo() {
     
     const statuses = Array.from(allStatuses);
     statuses.sort();
-    const columns = ["ip"].concat(statuses).concat(["Last seen", "Links"]);
+    const columns = ["ip"]
+      .concat(statuses)
+      .concat(["Hosting", "Country", "AS", "Score", "Offenses", "Active", "Last seen", "Links"]);
 
     ipsElement.innerHTML =
       "<thead><tr>" +
@@ -188,7 +307,18 @@ async function fetchAndDisplayInfo() {
       const others = statuses
         .map((s) => stats[s])
         .map((r) => `<td>${r == undefined ? "" : r}</td>`);
-      row.innerHTML = `<td>${ip}</td>${others.join("")}<td>${data["lastSeen"][ip]}</td>
+      const offense = data.offenses[ip];
+      const score = data.scores[ip];
+      const provider = data.providers[ip];
+      const geo = data.geo[ip] || {};
+      row.innerHTML = `<td>${ip}</td>${others.join("")}<td>${
+        provider == undefined ? "" : provider
+      }</td><td>${geo["country"] || ""}</td><td>${
+        geo["asn"] == undefined ? "" : "AS" + geo["asn"] + " " + (geo["org"] || "")
+      }</td><td>${score == undefined ? "" : score
+      }</td><td>${offense == undefined ? "" : offense["count"]
+      }</td><td>${data.activeByIp[ip] || ""
+      }</td><td>${data["lastSeen"][ip]}</td>
       <td><a href='https://ipinfo.io/${ip}'>ipinfo</a> <a href='https://www.abuseipdb.com/check/${ip}'>abuseip</a></td>`;
     }
 
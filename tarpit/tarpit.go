@@ -0,0 +1,107 @@
+package tarpit
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Tarpit slows down banned clients instead of refusing them right away, so scanners waste
+// their time on us. Connections are only held up to a global cap to keep our resources safe.
+type Tarpit struct {
+	slots chan struct{}
+	// Delay is how long the delay response waits before answering
+	Delay time.Duration
+	// DripInterval is the pause between two bytes of the tarpit response
+	DripInterval time.Duration
+	// DripDuration is how long the tarpit response lasts
+	DripDuration time.Duration
+
+	held     atomic.Int64
+	total    atomic.Int64
+	overflow atomic.Int64
+}
+
+// New holds at most maxConnections connections at once
+func New(maxConnections int, delay, dripInterval, dripDuration time.Duration) *Tarpit {
+	return &Tarpit{
+		slots:        make(chan struct{}, maxConnections),
+		Delay:        delay,
+		DripInterval: dripInterval,
+		DripDuration: dripDuration,
+	}
+}
+
+// acquire takes a slot, it reports false when the cap is reached
+func (t *Tarpit) acquire() bool {
+	select {
+	case t.slots <- struct{}{}:
+		t.held.Add(1)
+		t.total.Add(1)
+		return true
+	default:
+		t.overflow.Add(1)
+		return false
+	}
+}
+
+func (t *Tarpit) release() {
+	t.held.Add(-1)
+	<-t.slots
+}
+
+// DelayResponse waits Delay then calls respond, it reports false without answering when the cap is reached
+func (t *Tarpit) DelayResponse(r *http.Request, respond func()) bool {
+	if !t.acquire() {
+		return false
+	}
+	defer t.release()
+	timer := time.NewTimer(t.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		respond()
+	case <-r.Context().Done():
+	}
+	return true
+}
+
+// Drip sends the headers with status then a byte every DripInterval during DripDuration,
+// it reports false without answering when the cap is reached
+func (t *Tarpit) Drip(w http.ResponseWriter, r *http.Request, status int) bool {
+	if !t.acquire() {
+		return false
+	}
+	defer t.release()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	flusher, _ := w.(http.Flusher)
+	ticker := time.NewTicker(t.DripInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(t.DripDuration)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := w.Write([]byte(" ")); err != nil {
+				return true
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-deadline.C:
+			return true
+		case <-r.Context().Done():
+			return true
+		}
+	}
+}
+
+// Info returns the held connections and the counters since the start
+func (t *Tarpit) Info() map[string]int64 {
+	return map[string]int64{
+		"held":     t.held.Load(),
+		"total":    t.total.Load(),
+		"overflow": t.overflow.Load(),
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reverseproxy/rules"
+	"reverseproxy/tarpit"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTarpit(t *testing.T) {
+	pit := tarpit.New(1, 20*time.Millisecond, 5*time.Millisecond, 50*time.Millisecond)
+	page, err := NewBlockPage(http.StatusForbidden, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := newBlockInfo("192.0.2.1", "threshold", time.Now().Add(time.Minute))
+
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	if response := refuse(recorder, httptest.NewRequest("GET", "/", nil), pit, page, rules.ResponseTarpit, info); response != rules.ResponseTarpit {
+		t.Errorf("refuse() = %s, want tarpit", response)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("tarpit lasted %s, want at least 50ms", elapsed)
+	}
+	if recorder.Code != http.StatusForbidden || recorder.Body.Len() == 0 || strings.TrimSpace(recorder.Body.String()) != "" {
+		t.Errorf("tarpit answered %d %q, want 403 with dripped spaces", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	start = time.Now()
+	refuse(recorder, httptest.NewRequest("GET", "/", nil), pit, page, rules.ResponseDelay, info)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || recorder.Code != http.StatusForbidden {
+		t.Errorf("delay answered %d after %s, want 403 after 20ms", recorder.Code, elapsed)
+	}
+
+	// the single slot is held by a client, the next one gets an immediate 403
+	held := make(chan struct{})
+	go func() {
+		refuse(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), pit, page, rules.ResponseTarpit, info)
+		close(held)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for pit.Info()["held"] == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if response := refuse(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), pit, page, rules.ResponseTarpit, info); response != rules.ResponseForbidden {
+		t.Errorf("refuse() over the cap = %s, want forbidden", response)
+	}
+	<-held
+	if info := pit.Info(); info["held"] != 0 || info["total"] != 3 || info["overflow"] != 1 {
+		t.Errorf("Info() = %v", info)
+	}
+}
+
+func TestResponseFor(t *testing.T) {
+	engine, err := rules.NewEngine([]rules.Rule{
+		{Name: "slow", Glob: "/wp-login.php", Action: rules.ActionBan, Response: rules.ResponseTarpit},
+		{Name: "plain", Glob: "/.env", Action: rules.ActionBan},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		reason string
+		want   rules.Response
+	}{
+		{"rule:slow", rules.ResponseTarpit},
+		{"rule:plain", rules.ResponseDelay},
+		{"rule:unknown", rules.ResponseDelay},
+		{"threshold", rules.ResponseDelay},
+	}
+	for _, tt := range tests {
+		if got := responseFor(engine, tt.reason, rules.ResponseDelay); got != tt.want {
+			t.Errorf("responseFor(%q) = %s, want %s", tt.reason, got, tt.want)
+		}
+	}
+
+	if _, err := rules.NewEngine([]rules.Rule{{Name: "bad", Glob: "/x", Action: rules.ActionBan, Response: "teapot"}}); err == nil {
+		t.Errorf("NewEngine() with an unknown response should fail")
+	}
+}
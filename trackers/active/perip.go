@@ -0,0 +1,117 @@
+package active
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IPConnections tracks the in-flight requests per client ip like RecordActiveConnection does per path,
+// and caps them: over the cap a request waits up to queueTimeout for a slot, then is refused.
+// The ips without in-flight request are forgotten.
+type IPConnections struct {
+	max          int
+	queueTimeout time.Duration
+
+	mu    sync.Mutex
+	perIP map[string]*ipConnections
+
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+// ipConnections holds the in-flight requests of an ip
+type ipConnections struct {
+	// slots is nil without cap
+	slots chan struct{}
+	stats ConnectionStats
+	// refs counts the active and waiting requests, the entry is dropped at 0
+	refs int
+}
+
+// NewIPConnections caps the in-flight requests per ip to max, 0 only tracks them
+func NewIPConnections(max int, queueTimeout time.Duration) *IPConnections {
+	return &IPConnections{max: max, queueTimeout: queueTimeout, perIP: make(map[string]*ipConnections)}
+}
+
+// Acquire records an in-flight request of the ip, waiting for a slot when over the cap.
+// It reports false when the request must be refused, otherwise release must be called once done.
+func (c *IPConnections) Acquire(ctx context.Context, ip string) (func(), bool) {
+	c.mu.Lock()
+	conns, exists := c.perIP[ip]
+	if !exists {
+		conns = &ipConnections{}
+		if c.max > 0 {
+			conns.slots = make(chan struct{}, c.max)
+		}
+		c.perIP[ip] = conns
+	}
+	conns.refs++
+	c.mu.Unlock()
+
+	if conns.slots != nil && !c.takeSlot(ctx, conns) {
+		c.rejected.Add(1)
+		c.unref(ip, conns)
+		return nil, false
+	}
+
+	atomic.AddInt64(&conns.stats.ActiveConnections, 1)
+	return func() {
+		atomic.AddInt64(&conns.stats.ActiveConnections, -1)
+		if conns.slots != nil {
+			<-conns.slots
+		}
+		c.unref(ip, conns)
+	}, true
+}
+
+// takeSlot waits up to queueTimeout for a slot when the ip is at the cap
+func (c *IPConnections) takeSlot(ctx context.Context, conns *ipConnections) bool {
+	select {
+	case conns.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if c.queueTimeout <= 0 {
+		return false
+	}
+	c.queued.Add(1)
+	timer := time.NewTimer(c.queueTimeout)
+	defer timer.Stop()
+	select {
+	case conns.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *IPConnections) unref(ip string, conns *ipConnections) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns.refs--
+	if conns.refs == 0 {
+		delete(c.perIP, ip)
+	}
+}
+
+// GetActiveConnections returns the in-flight requests of the ips having some
+func (c *IPConnections) GetActiveConnections() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := make(map[string]int64, len(c.perIP))
+	for ip, conns := range c.perIP {
+		if count := conns.stats.GetActiveConnections(); count > 0 {
+			active[ip] = count
+		}
+	}
+	return active
+}
+
+// Stats returns how many requests waited for a slot and how many were refused since the start
+func (c *IPConnections) Stats() (int64, int64) {
+	return c.queued.Load(), c.rejected.Load()
+}
@@ -0,0 +1,182 @@
+package backendcost
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reason is the ban reason of the ips exceeding their backend budget
+const Reason = "backend-budget"
+
+// sample is the backend time of a request
+type sample struct {
+	ip      string
+	at      time.Time
+	seconds float64
+}
+
+// usage is the backend time of an ip in the window
+type usage struct {
+	seconds  float64
+	requests int
+}
+
+// Usage is the backend time of an ip in the window, see Top
+type Usage struct {
+	IP       string  `json:"ip"`
+	Seconds  float64 `json:"seconds"`
+	Share    float64 `json:"share"`
+	Requests int     `json:"requests"`
+}
+
+// Budget sums the backend time per ip over a sliding window, an ip using more than share of
+// the backend time of everybody is over budget. The share only applies once the total exceeds
+// minSeconds, so a lone client of a quiet backend isn't flagged.
+type Budget struct {
+	mu         sync.Mutex
+	window     time.Duration
+	share      float64
+	minSeconds float64
+	// samples are in arrival order, the expired ones are dropped from the front
+	samples []sample
+	perIP   map[string]*usage
+	total   float64
+	// overBudget counts the requests refused or banned over budget
+	overBudget int64
+}
+
+// NewBudget creates the budget, a share of 0 only tracks the backend time without enforcing anything
+func NewBudget(window time.Duration, share float64, minSeconds float64) *Budget {
+	return &Budget{window: window, share: share, minSeconds: minSeconds, perIP: make(map[string]*usage)}
+}
+
+// expire drops the samples out of the window, caller must hold the lock
+func (b *Budget) expire(now time.Time) {
+	cutoff := now.Add(-b.window)
+	expired := 0
+	for _, s := range b.samples {
+		if s.at.After(cutoff) {
+			break
+		}
+		expired++
+		b.total -= s.seconds
+		u := b.perIP[s.ip]
+		u.seconds -= s.seconds
+		u.requests--
+		if u.requests == 0 {
+			delete(b.perIP, s.ip)
+		}
+	}
+	if expired == 0 {
+		return
+	}
+	b.samples = b.samples[expired:]
+	if len(b.samples) == 0 {
+		// avoid float drift and release the backing array
+		b.samples = nil
+		b.total = 0
+	}
+}
+
+// Record adds the backend time of a request of the ip
+func (b *Budget) Record(ip string, seconds float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.expire(now)
+	b.samples = append(b.samples, sample{ip: ip, at: now, seconds: seconds})
+	b.total += seconds
+	u, exists := b.perIP[ip]
+	if !exists {
+		u = &usage{}
+		b.perIP[ip] = u
+	}
+	u.seconds += seconds
+	u.requests++
+}
+
+// Reset forgets the backend time of the ip, called when it is banned so it doesn't come back
+// from the ban still over budget
+func (b *Budget) Reset(ip string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, exists := b.perIP[ip]
+	if !exists {
+		return
+	}
+	kept := b.samples[:0]
+	for _, s := range b.samples {
+		if s.ip != ip {
+			kept = append(kept, s)
+		}
+	}
+	b.samples = kept
+	b.total -= u.seconds
+	delete(b.perIP, ip)
+	if len(b.samples) == 0 {
+		b.samples = nil
+		b.total = 0
+	}
+}
+
+// OverBudget reports if the ip uses more than its share of the backend time, with the time until
+// the oldest request leaves the window (the earliest the shares can change)
+func (b *Budget) OverBudget(ip string) (bool, time.Duration) {
+	if b == nil || b.share <= 0 {
+		return false, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.expire(now)
+	u, exists := b.perIP[ip]
+	if !exists || b.total < b.minSeconds || u.seconds <= b.share*b.total {
+		return false, 0
+	}
+	b.overBudget++
+	return true, b.samples[0].at.Add(b.window).Sub(now)
+}
+
+// Top returns the n ips with the most backend time in the window
+func (b *Budget) Top(n int) []Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	usages := make([]Usage, 0, len(b.perIP))
+	for ip, u := range b.perIP {
+		share := 0.0
+		if b.total > 0 {
+			share = u.seconds / b.total
+		}
+		usages = append(usages, Usage{IP: ip, Seconds: u.seconds, Share: share, Requests: u.requests})
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Seconds > usages[j].Seconds })
+	if len(usages) > n {
+		usages = usages[:n]
+	}
+	return usages
+}
+
+// Info returns the backend time of all the ips in the window and the over budget count since the start
+func (b *Budget) Info() (float64, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	return b.total, b.overBudget
+}
+
+// Janitor drops the expired samples every interval until ctx is done, even without traffic
+func (b *Budget) Janitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Info()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
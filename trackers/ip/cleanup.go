@@ -0,0 +1,95 @@
+package ip
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// cleanupStats counts what the janitor removed since the start
+type cleanupStats struct {
+	pruned  int64
+	evicted int64
+}
+
+// forget drops the counters of the ip, active bans and offense history are kept, caller must hold the lock
+func (t *IPTracker) forget(ip string) {
+	delete(t.hits, ip)
+	delete(t.lastSeen, ip)
+	delete(t.statusCountPerIp, ip)
+}
+
+// Prune forgets the ips not seen for maxIdle, then evicts the least recently seen ips
+// until at most maxEntries are tracked (0 disables the cap). Expired bans and shadow bans,
+// decayed hits and forgotten offenses are dropped too. It returns the number of pruned and evicted ips.
+func (t *IPTracker) Prune(maxIdle time.Duration, maxEntries int) (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	pruned := 0
+	for ip, seen := range t.lastSeen {
+		if maxIdle > 0 && now.Sub(seen) > maxIdle {
+			t.forget(ip)
+			pruned++
+		}
+	}
+	for ip := range t.hits {
+		t.windowedHits(ip, now)
+	}
+	for subnet := range t.subnets.hits {
+		pruneWindow(t.subnets.hits, subnet, now.Add(-t.window))
+	}
+	for ip, ban := range t.banned {
+		if now.After(ban.Until) {
+			delete(t.banned, ip)
+		}
+	}
+	for subnet, ban := range t.subnets.banned {
+		if now.After(ban.Until) {
+			delete(t.subnets.banned, subnet)
+		}
+	}
+	t.pruneOffenses(now)
+	t.pruneShadow(now)
+	// ips only known through their counters (never seen) can't be ordered, drop them
+	for ip := range t.statusCountPerIp {
+		if _, seen := t.lastSeen[ip]; !seen {
+			delete(t.statusCountPerIp, ip)
+		}
+	}
+
+	evicted := 0
+	if maxEntries > 0 && len(t.lastSeen) > maxEntries {
+		ips := make([]string, 0, len(t.lastSeen))
+		for ip := range t.lastSeen {
+			ips = append(ips, ip)
+		}
+		sort.Slice(ips, func(i, j int) bool { return t.lastSeen[ips[i]].Before(t.lastSeen[ips[j]]) })
+		for _, ip := range ips[:len(ips)-maxEntries] {
+			t.forget(ip)
+			evicted++
+		}
+	}
+
+	t.cleanup.pruned += int64(pruned)
+	t.cleanup.evicted += int64(evicted)
+	return pruned, evicted
+}
+
+// Janitor prunes the tracker every interval until ctx is done, see Prune
+func (t *IPTracker) Janitor(ctx context.Context, interval time.Duration, maxIdle time.Duration, maxEntries int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if pruned, evicted := t.Prune(maxIdle, maxEntries); pruned > 0 || evicted > 0 {
+				log.Printf("Cleanup: pruned %d idle ips, evicted %d ips over the cap", pruned, evicted)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ip
+
+import (
+	"log"
+	"time"
+)
+
+// BanEvent tells the listeners a ban started, or was lifted when Unban is set
+type BanEvent struct {
+	Target string `json:"target"`
+	// Kind is "ip", "subnet" or "range", see BanEntry
+	Kind  string `json:"kind"`
+	Ban   Ban    `json:"ban"`
+	Unban bool   `json:"unban,omitempty"`
+	// Origin is the instance the event was replicated from, "" for our own decisions
+	Origin string `json:"origin,omitempty"`
+}
+
+// eventQueueSize bounds the events waiting for slow listeners (ex: firewall commands)
+const eventQueueSize = 4096
+
+// OnBan registers a listener called for every ban and unban, in order, from a dedicated
+// goroutine so slow listeners never block the requests. Expired bans are not notified.
+func (t *IPTracker) OnBan(listener func(BanEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.events == nil {
+		t.events = make(chan BanEvent, eventQueueSize)
+		go t.dispatchEvents()
+	}
+	t.listeners = append(t.listeners, listener)
+}
+
+func (t *IPTracker) dispatchEvents() {
+	for event := range t.events {
+		t.mu.Lock()
+		listeners := t.listeners
+		t.mu.Unlock()
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
+
+// notify queues the event for the listeners, caller must hold the lock
+func (t *IPTracker) notify(event BanEvent) {
+	if t.events == nil {
+		return
+	}
+	select {
+	case t.events <- event:
+	default:
+		log.Printf("Ban event queue full, dropping %s event for %s", eventName(event), event.Target)
+	}
+}
+
+func eventName(event BanEvent) string {
+	if event.Unban {
+		return "unban"
+	}
+	return "ban"
+}
+
+// Remaining returns how long the ban still lasts
+func (b Ban) Remaining() time.Duration {
+	return time.Until(b.Until)
+}
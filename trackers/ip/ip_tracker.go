@@ -3,56 +3,251 @@ package ip
 import (
 	"fmt"
 	"log"
+	"reverseproxy/ipinfo"
+	"reverseproxy/ipranges"
 	"sync"
 	"time"
 
 	"golang.org/x/sys/unix"
 )
 
+// Ban describes an active ban of an ip
+type Ban struct {
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Offenses int       `json:"offenses"`
+	Reason   string    `json:"reason"`
+	Comment  string    `json:"comment,omitempty"`
+	// Origin is the instance which decided the ban when replicated from a peer
+	Origin string `json:"origin,omitempty"`
+}
+
+const (
+	// ReasonThreshold is the ban reason of ips exceeding the score threshold
+	ReasonThreshold = "threshold"
+	// ReasonHoneypot is the ban reason of ips requesting a trap path
+	ReasonHoneypot = "honeypot"
+	// ReasonDenyList is reported by BanOf for the ips of the denylist
+	ReasonDenyList = "denylist"
+)
+
+// Offense keeps the ban history of an ip to escalate the next ban duration
+type Offense struct {
+	Count   int       `json:"count"`
+	LastBan time.Time `json:"lastBan"`
+	Until   time.Time `json:"until"`
+}
+
+// hit is a weighted event counting against the threshold of an ip
+type hit struct {
+	at     time.Time
+	weight float64
+}
+
 type IPTracker struct {
 	mu               sync.Mutex
-	hits             map[string]int
+	hits             map[string][]hit
 	lastSeen         map[string]time.Time
-	banned           map[string]time.Time
+	banned           map[string]Ban
+	offenses         map[string]*Offense
 	statusCountPerIp map[string]map[int]int
 	threshold        int
+	window           time.Duration
 	banDuration      time.Duration
+	banSteps         []time.Duration
+	maxBanDuration   time.Duration
+	offenseTTL       time.Duration
+	allowList        *ipranges.List
+	denyList         *ipranges.List
+	subnets          subnetAggregation
+	hostingThreshold int
+	provider         func(ip string) string
+	geo              func(ip string) ipinfo.Geo
+	cleanup          cleanupStats
+	rangeBans        map[string]rangeBan
+	shadow           shadowState
+	events           chan BanEvent
+	listeners        []func(BanEvent)
 }
 
-func NewIPTracker(threshold int, banDuration time.Duration) *IPTracker {
+// NewIPTracker creates a tracker banning an ip once it exceeds threshold hits
+// within the sliding window.
+func NewIPTracker(threshold int, window time.Duration, banDuration time.Duration) *IPTracker {
 	return &IPTracker{
-		hits:             make(map[string]int),
+		hits:             make(map[string][]hit),
 		lastSeen:         make(map[string]time.Time),
-		banned:           make(map[string]time.Time),
+		banned:           make(map[string]Ban),
+		offenses:         make(map[string]*Offense),
 		statusCountPerIp: make(map[string]map[int]int),
+		subnets:          newSubnetAggregation(),
+		rangeBans:        make(map[string]rangeBan),
 		threshold:        threshold,
+		window:           window,
 		banDuration:      banDuration,
 	}
 }
 
+// SetBanEscalation makes repeated offenders get longer bans: the nth ban lasts steps[n-1]
+// (the last step is reused afterwards) capped at maxDuration. The offense history of an ip
+// is forgotten after historyTTL without being banned.
+func (t *IPTracker) SetBanEscalation(steps []time.Duration, maxDuration time.Duration, historyTTL time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.banSteps = steps
+	t.maxBanDuration = maxDuration
+	t.offenseTTL = historyTTL
+}
+
+// SetAccessLists configures the ranges that are never banned (allow) and always banned (deny),
+// the lists can be reloaded in place. The allow list wins if a range is in both.
+func (t *IPTracker) SetAccessLists(allow *ipranges.List, deny *ipranges.List) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.allowList = allow
+	t.denyList = deny
+}
+
+// SetHosting tags the ips with their hosting provider, ips from a datacenter are banned
+// once their score exceeds hostingThreshold instead of the regular threshold (0 keeps the regular one)
+func (t *IPTracker) SetHosting(provider func(ip string) string, hostingThreshold int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.provider = provider
+	t.hostingThreshold = hostingThreshold
+}
+
+// thresholdFor returns the threshold applying to the ip, caller must hold the lock
+func (t *IPTracker) thresholdFor(ip string) float64 {
+	if t.hostingThreshold > 0 && t.providerOf(ip) != "" {
+		return float64(t.hostingThreshold)
+	}
+	return float64(t.threshold)
+}
+
+// SetGeoIP tags the ips with their country and autonomous system
+func (t *IPTracker) SetGeoIP(geo func(ip string) ipinfo.Geo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.geo = geo
+}
+
+func (t *IPTracker) providerOf(ip string) string {
+	if t.provider == nil {
+		return ""
+	}
+	return t.provider(ip)
+}
+
+// IsAllowed reports if the ip is part of the allow list
+func (t *IPTracker) IsAllowed(ip string) bool {
+	return t.allowList.Contains(ip)
+}
+
 func (t *IPTracker) CheckBan(ip string) bool {
+	_, banned := t.BanOf(ip)
+	return banned
+}
+
+// BanOf returns the ban applying to the ip: its own, the one of its subnet or of a banned range.
+// Denied ips get a ban with the denylist reason and no end.
+func (t *IPTracker) BanOf(ip string) (Ban, bool) {
+	if t.IsAllowed(ip) {
+		return Ban{}, false
+	}
+	if t.denyList.Contains(ip) {
+		return Ban{Reason: ReasonDenyList}, true
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if banTime, banned := t.banned[ip]; banned {
-		if time.Since(banTime) > t.banDuration {
+	if ban, banned := t.banned[ip]; banned {
+		if time.Now().After(ban.Until) {
 			delete(t.banned, ip) // Unban IP after duration
 		} else {
-			return true // Still banned
+			return ban, true // Still banned
 		}
 	}
-	return false
+	if ban, banned := t.checkSubnetBan(ip); banned {
+		return ban, true
+	}
+	return t.checkRangeBan(ip)
 }
 
+// banDurationFor returns the duration of the nth ban of an ip
+func (t *IPTracker) banDurationFor(offenses int) time.Duration {
+	duration := t.banDuration
+	if len(t.banSteps) > 0 {
+		duration = t.banSteps[min(offenses, len(t.banSteps))-1]
+	}
+	if t.maxBanDuration > 0 && duration > t.maxBanDuration {
+		duration = t.maxBanDuration
+	}
+	return duration
+}
+
+// nextBan records a new offense for the ip (or subnet) and returns its ban, caller must hold the lock
+func (t *IPTracker) nextBan(key string, now time.Time, reason string) Ban {
+	offense, exists := t.offenses[key]
+	if !exists || (t.offenseTTL > 0 && now.Sub(offense.Until) > t.offenseTTL) {
+		offense = &Offense{}
+		t.offenses[key] = offense
+	}
+	offense.Count++
+	duration := t.banDurationFor(offense.Count)
+	offense.LastBan = now
+	offense.Until = now.Add(duration)
+
+	return Ban{Since: now, Until: offense.Until, Offenses: offense.Count, Reason: reason}
+}
+
+// pruneOffenses forgets the history of ips which stayed quiet long enough, caller must hold the lock
+func (t *IPTracker) pruneOffenses(now time.Time) {
+	if t.offenseTTL <= 0 {
+		return
+	}
+	for ip, offense := range t.offenses {
+		if now.Sub(offense.Until) > t.offenseTTL {
+			delete(t.offenses, ip)
+		}
+	}
+}
+
+// IncrementHit counts a 404 of the ip against its threshold
 func (t *IPTracker) IncrementHit(ip string) {
+	t.AddScore(ip, 1)
+}
+
+// AddScore adds a weighted hit to the ip, banning it once the score within the window exceeds the threshold
+func (t *IPTracker) AddScore(ip string, weight float64) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.hits[ip]++
-	if t.hits[ip] > t.threshold {
-		t.banned[ip] = time.Now()
-		delete(t.hits, ip) // Reset count after banning
-		log.Printf("Banned IP: %s", ip)
+	now := time.Now()
+	t.lastSeen[ip] = now
+	t.hits[ip] = append(t.windowedHits(ip, now), hit{at: now, weight: weight})
+	t.checkShadowThreshold(ip, now)
+	if sumWeights(t.hits[ip]) > t.thresholdFor(ip) && !t.allowList.Contains(ip) {
+		t.banLocked(ip, now, ReasonThreshold)
 	}
+	t.addSubnetScore(ip, weight, now)
+}
+
+// Ban bans the ip right away unless it is allowed, the reason is kept for the dashboard
+func (t *IPTracker) Ban(ip string, reason string) {
+	if t.IsAllowed(ip) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.banLocked(ip, time.Now(), reason)
+}
+
+func (t *IPTracker) banLocked(ip string, now time.Time, reason string) {
+	ban := t.nextBan(ip, now, reason)
+	t.banned[ip] = ban
+	t.notify(BanEvent{Target: ip, Kind: "ip", Ban: ban})
+	delete(t.hits, ip) // Reset count after banning
+	log.Printf("Banned IP: %s until %s (offense #%d, reason %s)", ip, ban.Until.Format(time.RFC3339), ban.Offenses, reason)
 }
 
 func (t *IPTracker) IncrementStatus(ip string, statusCode int) {
@@ -67,10 +262,47 @@ func (t *IPTracker) IncrementStatus(ip string, statusCode int) {
 	t.statusCountPerIp[ip][statusCode]++
 }
 
+func sumWeights(hits []hit) float64 {
+	var score float64
+	for _, h := range hits {
+		score += h.weight
+	}
+	return score
+}
+
+// windowedHits drops the hits older than the window, caller must hold the lock
+func (t *IPTracker) windowedHits(ip string, now time.Time) []hit {
+	return pruneWindow(t.hits, ip, now.Add(-t.window))
+}
+
+// pruneWindow drops the hits of the key up to the cutoff, removing the key once empty
+func pruneWindow(hitsByKey map[string][]hit, key string, cutoff time.Time) []hit {
+	hits := hitsByKey[key]
+	i := 0
+	for i < len(hits) && !hits[i].at.After(cutoff) {
+		i++
+	}
+	if i == len(hits) {
+		delete(hitsByKey, key)
+		return nil
+	}
+	hits = hits[i:]
+	hitsByKey[key] = hits
+	return hits
+}
+
+// GetHits returns the number of hits of the ip within the current window
 func (t *IPTracker) GetHits(ip string) int {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.hits[ip]
+	return len(t.windowedHits(ip, time.Now()))
+}
+
+// GetScore returns the sum of the weights of the hits of the ip within the current window
+func (t *IPTracker) GetScore(ip string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return sumWeights(t.windowedHits(ip, time.Now()))
 }
 
 func getDiskUsage(path string) string {
@@ -106,6 +338,20 @@ func getDiskUsage(path string) string {
 }
 
 func (t *IPTracker) GetTrackerInfo() map[string]interface{} {
+	info, geo, ips := t.trackerInfo()
+	// the geoip lookups are done once the lock is released, not to stall the requests
+	geos := make(map[string]ipinfo.Geo, len(ips))
+	if geo != nil {
+		for _, ip := range ips {
+			geos[ip] = geo(ip)
+		}
+	}
+	info["geo"] = geos
+	return info
+}
+
+// trackerInfo returns the info under the lock with the geoip lookup and the tracked ips to enrich
+func (t *IPTracker) trackerInfo() (map[string]interface{}, func(ip string) ipinfo.Geo, []string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
@@ -137,10 +383,55 @@ func (t *IPTracker) GetTrackerInfo() map[string]interface{} {
 	var loadAverage = fmt.Sprintf("1-min: %.2f, 5-min: %.2f, 15-min: %.2f", load1, load5, load15)
 	var usage = getDiskUsage("/")
 
+	now := time.Now()
+	hits := make(map[string]int, len(t.hits))
+	scores := make(map[string]float64, len(t.hits))
+	for ip := range t.hits {
+		if windowed := t.windowedHits(ip, now); len(windowed) > 0 {
+			hits[ip] = len(windowed)
+			scores[ip] = sumWeights(windowed)
+		}
+	}
+
+	t.pruneOffenses(now)
+	banned := make(map[string]Ban, len(t.banned))
+	for ip, ban := range t.banned {
+		if now.After(ban.Until) {
+			delete(t.banned, ip)
+			continue
+		}
+		banned[ip] = ban
+	}
+	bannedSubnets, subnetScores := t.subnetInfo(now)
+	providers := make(map[string]string)
+	ips := make([]string, 0, len(t.lastSeen))
+	for ip := range t.lastSeen {
+		if provider := t.providerOf(ip); provider != "" {
+			providers[ip] = provider
+		}
+		ips = append(ips, ip)
+	}
+	offenses := make(map[string]Offense, len(t.offenses))
+	for ip, offense := range t.offenses {
+		offenses[ip] = *offense
+	}
+
 	return map[string]interface{}{
-		"hits":               t.hits,
+		"hits":               hits,
+		"hitsWindow":         t.window.String(),
+		"scores":             scores,
 		"lastSeen":           t.lastSeen,
-		"banned":             t.banned,
+		"banned":             banned,
+		"offenses":           offenses,
+		"bannedSubnets":      bannedSubnets,
+		"subnetScores":       subnetScores,
+		"providers":          providers,
+		"shadow":             t.shadowInfo(now),
+		"system.trackedIps":  len(t.lastSeen),
+		"system.prunedIps":   t.cleanup.pruned,
+		"system.evictedIps":  t.cleanup.evicted,
+		"allowListSize":      t.allowList.Len(),
+		"denyListSize":       t.denyList.Len(),
 		"statusCountPerIp":   t.statusCountPerIp,
 		"system.memTotalMB":  totalMemoryMB,
 		"system.memFreeMB":   freeMemoryMB,
@@ -148,12 +439,23 @@ func (t *IPTracker) GetTrackerInfo() map[string]interface{} {
 		"system.uptime":      uptime,
 		"system.loadAverage": loadAverage,
 		"system.diskUsage":   usage,
-	}
+	}, t.geo, ips
 }
 
 func (t *IPTracker) UnbanAll() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.banned = make(map[string]time.Time)
+	for ip, ban := range t.banned {
+		t.notify(BanEvent{Target: ip, Kind: "ip", Ban: ban, Unban: true})
+	}
+	for subnet, ban := range t.subnets.banned {
+		t.notify(BanEvent{Target: subnet, Kind: "subnet", Ban: ban, Unban: true})
+	}
+	for key, rangeBan := range t.rangeBans {
+		t.notify(BanEvent{Target: key, Kind: "range", Ban: rangeBan.ban, Unban: true})
+	}
+	t.banned = make(map[string]Ban)
+	t.subnets.banned = make(map[string]Ban)
+	t.rangeBans = make(map[string]rangeBan)
 	log.Println("All IPs have been unbanned.")
 }
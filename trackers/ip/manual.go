@@ -0,0 +1,182 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"reverseproxy/ipranges"
+	"sort"
+	"time"
+)
+
+// ReasonManual is the ban reason of the bans added through the api
+const ReasonManual = "manual"
+
+// ErrAllowed is returned when banning an allowed ip, the ban would never apply
+var ErrAllowed = errors.New("ip is part of the allow list")
+
+// BanEntry is a ban with what it targets, see ListBans
+type BanEntry struct {
+	Target string `json:"target"`
+	// Kind is "ip", "subnet" (aggregation ban) or "range" (manual CIDR ban)
+	Kind string `json:"kind"`
+	Ban
+}
+
+// rangeBan is a manual ban of a CIDR range
+type rangeBan struct {
+	prefix netip.Prefix
+	ban    Ban
+}
+
+// ManualBan bans an ip or a CIDR range for the duration, it returns the normalized target
+func (t *IPTracker) ManualBan(target string, duration time.Duration, comment string) (string, Ban, error) {
+	if duration <= 0 {
+		return "", Ban{}, fmt.Errorf("invalid duration %v", duration)
+	}
+	prefix, err := ipranges.ParsePrefix(target)
+	if err != nil {
+		return "", Ban{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	ban := Ban{Since: now, Until: now.Add(duration), Reason: ReasonManual, Comment: comment}
+	if prefix.IsSingleIP() {
+		ip := prefix.Addr().String()
+		if t.allowList.Contains(ip) {
+			return ip, Ban{}, ErrAllowed
+		}
+		if offense, exists := t.offenses[ip]; exists {
+			ban.Offenses = offense.Count
+		}
+		t.banned[ip] = ban
+		t.notify(BanEvent{Target: ip, Kind: "ip", Ban: ban})
+		log.Printf("Banned IP: %s until %s (manual: %s)", ip, ban.Until.Format(time.RFC3339), comment)
+		return ip, ban, nil
+	}
+	key := prefix.String()
+	t.rangeBans[key] = rangeBan{prefix: prefix, ban: ban}
+	t.notify(BanEvent{Target: key, Kind: "range", Ban: ban})
+	log.Printf("Banned range: %s until %s (manual: %s)", key, ban.Until.Format(time.RFC3339), comment)
+	return key, ban, nil
+}
+
+// Unban lifts the ban of an ip, an aggregation subnet or a manual range, it reports if there was one
+func (t *IPTracker) Unban(target string) bool {
+	prefix, err := ipranges.ParsePrefix(target)
+	if err != nil {
+		return false
+	}
+	key := prefix.String()
+	if prefix.IsSingleIP() {
+		key = prefix.Addr().String()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.unbanLocked(key, "")
+}
+
+// unbanLocked lifts the ban of the normalized target, origin is the instance asking for it ("" for us),
+// caller must hold the lock
+func (t *IPTracker) unbanLocked(key string, origin string) bool {
+	ipBan, bannedIP := t.banned[key]
+	subnetBan, bannedSubnet := t.subnets.banned[key]
+	rangeBan, bannedRange := t.rangeBans[key]
+	delete(t.banned, key)
+	delete(t.subnets.banned, key)
+	delete(t.rangeBans, key)
+	switch {
+	case bannedIP:
+		t.notify(BanEvent{Target: key, Kind: "ip", Ban: ipBan, Unban: true, Origin: origin})
+	case bannedSubnet:
+		t.notify(BanEvent{Target: key, Kind: "subnet", Ban: subnetBan, Unban: true, Origin: origin})
+	case bannedRange:
+		t.notify(BanEvent{Target: key, Kind: "range", Ban: rangeBan.ban, Unban: true, Origin: origin})
+	default:
+		return false
+	}
+	log.Printf("Unbanned %s", key)
+	return true
+}
+
+// ResetIP forgets the counters and the offense history of the ip, its active ban is kept.
+// It returns the normalized ip and reports if it was tracked.
+func (t *IPTracker) ResetIP(target string) (string, bool, error) {
+	prefix, err := ipranges.ParsePrefix(target)
+	if err != nil {
+		return "", false, err
+	}
+	if !prefix.IsSingleIP() {
+		return "", false, fmt.Errorf("not a single ip: %q", target)
+	}
+	ip := prefix.Addr().String()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, seen := t.lastSeen[ip]
+	_, hit := t.hits[ip]
+	_, offended := t.offenses[ip]
+	t.forget(ip)
+	delete(t.offenses, ip)
+	return ip, seen || hit || offended, nil
+}
+
+// ListBans returns the active bans of ips, subnets and ranges, the ones ending first first
+func (t *IPTracker) ListBans() []BanEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	entries := []BanEntry{}
+	for ip, ban := range t.banned {
+		if now.Before(ban.Until) {
+			entries = append(entries, BanEntry{Target: ip, Kind: "ip", Ban: ban})
+		}
+	}
+	for subnet, ban := range t.subnets.banned {
+		if now.Before(ban.Until) {
+			entries = append(entries, BanEntry{Target: subnet, Kind: "subnet", Ban: ban})
+		}
+	}
+	for key, rangeBan := range t.rangeBans {
+		if now.Before(rangeBan.ban.Until) {
+			entries = append(entries, BanEntry{Target: key, Kind: "range", Ban: rangeBan.ban})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Until.Before(entries[j].Until) })
+	return entries
+}
+
+// checkRangeBan returns the ban of the manually banned range holding the ip, caller must hold the lock
+func (t *IPTracker) checkRangeBan(ip string) (Ban, bool) {
+	if len(t.rangeBans) == 0 {
+		return Ban{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Ban{}, false
+	}
+	addr = addr.Unmap()
+	now := time.Now()
+	for key, rangeBan := range t.rangeBans {
+		if now.After(rangeBan.ban.Until) {
+			delete(t.rangeBans, key)
+			continue
+		}
+		if rangeBan.prefix.Contains(addr) {
+			return rangeBan.ban, true
+		}
+	}
+	return Ban{}, false
+}
+
+// ResetScore forgets the windowed hits of the ip, ex: once it proved it is a browser
+func (t *IPTracker) ResetScore(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.hits, ip)
+}
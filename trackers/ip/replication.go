@@ -0,0 +1,58 @@
+package ip
+
+import (
+	"fmt"
+	"log"
+	"reverseproxy/ipranges"
+	"time"
+)
+
+// ApplyRemote merges a ban or an unban replicated from the origin instance. Allowed ips are never
+// banned, expired bans are ignored and so are bans not ending after the current one (ex: pulled
+// again after a push). The listeners are notified with
+// the origin so the event is not replicated again.
+func (t *IPTracker) ApplyRemote(event BanEvent, origin string) error {
+	prefix, err := ipranges.ParsePrefix(event.Target)
+	if err != nil {
+		return err
+	}
+	key := prefix.String()
+	if prefix.IsSingleIP() {
+		key = prefix.Addr().String()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if event.Unban {
+		t.unbanLocked(key, origin)
+		return nil
+	}
+
+	ban := event.Ban
+	ban.Origin = origin
+	if time.Now().After(ban.Until) {
+		return nil
+	}
+	switch event.Kind {
+	case "ip":
+		if t.allowList.Contains(key) || !ban.Until.After(t.banned[key].Until) {
+			return nil
+		}
+		t.banned[key] = ban
+	case "subnet", "range":
+		// subnets are merged as ranges, they must apply even without local subnet aggregation
+		if !ban.Until.After(t.rangeBans[key].ban.Until) {
+			return nil
+		}
+		t.rangeBans[key] = rangeBan{prefix: prefix, ban: ban}
+	default:
+		return fmt.Errorf("unknown ban kind %q", event.Kind)
+	}
+	kind := event.Kind
+	if kind == "subnet" {
+		kind = "range"
+	}
+	t.notify(BanEvent{Target: key, Kind: kind, Ban: ban, Origin: origin})
+	log.Printf("Banned %s: %s until %s (reason %s, from %s)", kind, key, ban.Until.Format(time.RFC3339), ban.Reason, origin)
+	return nil
+}
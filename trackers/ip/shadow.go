@@ -0,0 +1,98 @@
+package ip
+
+import (
+	"log"
+	"time"
+)
+
+// maxShadowDecisions bounds the shadow decisions kept for the dashboard, the oldest are dropped first
+const maxShadowDecisions = 1000
+
+// ShadowDecision is a ban (or block) a rule or the shadow threshold would have done if enforced
+type ShadowDecision struct {
+	At     time.Time `json:"at"`
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Action string    `json:"action"`
+	Path   string    `json:"path,omitempty"`
+}
+
+// shadowState tracks the decisions of the rules and threshold running in shadow mode
+type shadowState struct {
+	threshold float64
+	// until avoids recording the shadow threshold again for an ip it would have banned
+	until     map[string]time.Time
+	decisions []ShadowDecision
+}
+
+// SetShadowThreshold records the ips whose score goes above threshold without banning them,
+// to try a new threshold while the current one keeps enforcing. 0 disables it.
+func (t *IPTracker) SetShadowThreshold(threshold float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.shadow.threshold = threshold
+}
+
+// RecordShadow records what a shadow rule would have done, allowed ips are ignored like for real bans
+func (t *IPTracker) RecordShadow(ip string, reason string, action string, path string) {
+	if t.IsAllowed(ip) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recordShadowLocked(ShadowDecision{At: time.Now(), IP: ip, Reason: reason, Action: action, Path: path})
+}
+
+// WouldBan reports if adding weight to the score of the ip would get it banned
+func (t *IPTracker) WouldBan(ip string, weight float64) bool {
+	if t.IsAllowed(ip) {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return sumWeights(t.windowedHits(ip, time.Now()))+weight > t.thresholdFor(ip)
+}
+
+// checkShadowThreshold records the ip once per ban duration when its score exceeds the shadow threshold,
+// caller must hold the lock
+func (t *IPTracker) checkShadowThreshold(ip string, now time.Time) {
+	if t.shadow.threshold <= 0 || now.Before(t.shadow.until[ip]) || t.allowList.Contains(ip) {
+		return
+	}
+	if sumWeights(t.hits[ip]) <= t.shadow.threshold {
+		return
+	}
+	if t.shadow.until == nil {
+		t.shadow.until = make(map[string]time.Time)
+	}
+	t.shadow.until[ip] = now.Add(t.banDuration)
+	t.recordShadowLocked(ShadowDecision{At: now, IP: ip, Reason: ReasonThreshold, Action: "ban"})
+}
+
+// recordShadowLocked keeps the decision, caller must hold the lock
+func (t *IPTracker) recordShadowLocked(decision ShadowDecision) {
+	log.Printf("Shadow %s of IP: %s (reason %s)", decision.Action, decision.IP, decision.Reason)
+	if len(t.shadow.decisions) >= maxShadowDecisions {
+		t.shadow.decisions = t.shadow.decisions[1:]
+	}
+	t.shadow.decisions = append(t.shadow.decisions, decision)
+}
+
+// pruneShadow drops the expired shadow bans, caller must hold the lock
+func (t *IPTracker) pruneShadow(now time.Time) {
+	for ip, until := range t.shadow.until {
+		if now.After(until) {
+			delete(t.shadow.until, ip)
+		}
+	}
+}
+
+// shadowInfo returns the shadow decisions, the most recent first, caller must hold the lock
+func (t *IPTracker) shadowInfo(now time.Time) []ShadowDecision {
+	t.pruneShadow(now)
+	decisions := make([]ShadowDecision, len(t.shadow.decisions))
+	for i, decision := range t.shadow.decisions {
+		decisions[len(decisions)-1-i] = decision
+	}
+	return decisions
+}
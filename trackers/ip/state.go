@@ -0,0 +1,181 @@
+package ip
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HitState is a persisted weighted hit
+type HitState struct {
+	At     time.Time `json:"at"`
+	Weight float64   `json:"weight"`
+}
+
+// State is what survives a restart of banme: bans, offense history and per ip counters
+type State struct {
+	SavedAt          time.Time              `json:"savedAt"`
+	Banned           map[string]Ban         `json:"banned"`
+	BannedSubnets    map[string]Ban         `json:"bannedSubnets"`
+	RangeBans        map[string]Ban         `json:"rangeBans"`
+	Offenses         map[string]Offense     `json:"offenses"`
+	Hits             map[string][]HitState  `json:"hits"`
+	SubnetHits       map[string][]HitState  `json:"subnetHits"`
+	LastSeen         map[string]time.Time   `json:"lastSeen"`
+	StatusCountPerIp map[string]map[int]int `json:"statusCountPerIp"`
+}
+
+func toHitStates(hitsByKey map[string][]hit) map[string][]HitState {
+	states := make(map[string][]HitState, len(hitsByKey))
+	for key, hits := range hitsByKey {
+		for _, h := range hits {
+			states[key] = append(states[key], HitState{At: h.at, Weight: h.weight})
+		}
+	}
+	return states
+}
+
+func fromHitStates(states map[string][]HitState, cutoff time.Time) map[string][]hit {
+	hitsByKey := make(map[string][]hit, len(states))
+	for key, hitStates := range states {
+		for _, state := range hitStates {
+			if state.At.After(cutoff) {
+				hitsByKey[key] = append(hitsByKey[key], hit{at: state.At, weight: state.Weight})
+			}
+		}
+	}
+	return hitsByKey
+}
+
+func activeBans(bans map[string]Ban, now time.Time) map[string]Ban {
+	active := make(map[string]Ban, len(bans))
+	for key, ban := range bans {
+		if now.Before(ban.Until) {
+			active[key] = ban
+		}
+	}
+	return active
+}
+
+// Snapshot copies the state of the tracker
+func (t *IPTracker) Snapshot() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	offenses := make(map[string]Offense, len(t.offenses))
+	for key, offense := range t.offenses {
+		offenses[key] = *offense
+	}
+	lastSeen := make(map[string]time.Time, len(t.lastSeen))
+	for ip, seen := range t.lastSeen {
+		lastSeen[ip] = seen
+	}
+	statusCountPerIp := make(map[string]map[int]int, len(t.statusCountPerIp))
+	for ip, counts := range t.statusCountPerIp {
+		statusCountPerIp[ip] = make(map[int]int, len(counts))
+		for status, count := range counts {
+			statusCountPerIp[ip][status] = count
+		}
+	}
+	rangeBans := make(map[string]Ban, len(t.rangeBans))
+	for key, rangeBan := range t.rangeBans {
+		rangeBans[key] = rangeBan.ban
+	}
+	return State{
+		SavedAt:          now,
+		RangeBans:        activeBans(rangeBans, now),
+		Banned:           activeBans(t.banned, now),
+		BannedSubnets:    activeBans(t.subnets.banned, now),
+		Offenses:         offenses,
+		Hits:             toHitStates(t.hits),
+		SubnetHits:       toHitStates(t.subnets.hits),
+		LastSeen:         lastSeen,
+		StatusCountPerIp: statusCountPerIp,
+	}
+}
+
+// Restore replaces the state of the tracker, expired bans, forgotten offenses and
+// hits out of the window are dropped
+func (t *IPTracker) Restore(state State) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.banned = activeBans(state.Banned, now)
+	t.subnets.banned = activeBans(state.BannedSubnets, now)
+	t.rangeBans = make(map[string]rangeBan)
+	for key, ban := range activeBans(state.RangeBans, now) {
+		if prefix, err := netip.ParsePrefix(key); err == nil {
+			t.rangeBans[key] = rangeBan{prefix: prefix, ban: ban}
+		}
+	}
+	t.offenses = make(map[string]*Offense, len(state.Offenses))
+	for key, offense := range state.Offenses {
+		offense := offense
+		t.offenses[key] = &offense
+	}
+	t.pruneOffenses(now)
+	t.hits = fromHitStates(state.Hits, now.Add(-t.window))
+	t.subnets.hits = fromHitStates(state.SubnetHits, now.Add(-t.window))
+	t.lastSeen = make(map[string]time.Time)
+	for ip, seen := range state.LastSeen {
+		t.lastSeen[ip] = seen
+	}
+	t.statusCountPerIp = make(map[string]map[int]int)
+	for ip, counts := range state.StatusCountPerIp {
+		t.statusCountPerIp[ip] = counts
+	}
+
+	// the listeners (ex: firewall) may have lost the bans with the restart
+	for ip, ban := range t.banned {
+		t.notify(BanEvent{Target: ip, Kind: "ip", Ban: ban, Origin: ban.Origin})
+	}
+	for subnet, ban := range t.subnets.banned {
+		t.notify(BanEvent{Target: subnet, Kind: "subnet", Ban: ban, Origin: ban.Origin})
+	}
+	for key, rangeBan := range t.rangeBans {
+		t.notify(BanEvent{Target: key, Kind: "range", Ban: rangeBan.ban, Origin: rangeBan.ban.Origin})
+	}
+}
+
+// SaveState writes the state to the file, through a temporary file so a crash
+// while saving never leaves a truncated state behind
+func (t *IPTracker) SaveState(path string) error {
+	content, err := json.Marshal(t.Snapshot())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState restores the state saved in the file, a missing file is not an error
+func (t *IPTracker) LoadState(path string) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state State
+	if err := json.Unmarshal(content, &state); err != nil {
+		return err
+	}
+	t.Restore(state)
+	return nil
+}
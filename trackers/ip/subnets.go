@@ -0,0 +1,114 @@
+package ip
+
+import (
+	"log"
+	"net/netip"
+	"time"
+)
+
+// subnetAggregation sums the scores of the ips per prefix to catch scanners rotating
+// their address within an ipv6 /64 or a cloud ipv4 /24
+type subnetAggregation struct {
+	v4Bits    int
+	v6Bits    int
+	threshold float64
+	hits      map[string][]hit
+	banned    map[string]Ban
+}
+
+func newSubnetAggregation() subnetAggregation {
+	return subnetAggregation{
+		hits:   make(map[string][]hit),
+		banned: make(map[string]Ban),
+	}
+}
+
+// SetSubnetAggregation bans a whole prefix (v4Bits for ipv4, v6Bits for ipv6) once the summed
+// score of its ips within the window exceeds threshold, a threshold of 0 disables it.
+func (t *IPTracker) SetSubnetAggregation(v4Bits int, v6Bits int, threshold float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subnets.v4Bits = v4Bits
+	t.subnets.v6Bits = v6Bits
+	t.subnets.threshold = threshold
+}
+
+// subnetOf returns the aggregation prefix of the ip like "192.0.2.0/24", or "" if disabled,
+// caller must hold the lock
+func (t *IPTracker) subnetOf(ip string) string {
+	if t.subnets.threshold <= 0 {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := t.subnets.v6Bits
+	if addr.Is4() {
+		bits = t.subnets.v4Bits
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// addSubnetScore adds the weight to the subnet of the ip, caller must hold the lock
+func (t *IPTracker) addSubnetScore(ip string, weight float64, now time.Time) {
+	subnet := t.subnetOf(ip)
+	if subnet == "" {
+		return
+	}
+	hits := append(pruneWindow(t.subnets.hits, subnet, now.Add(-t.window)), hit{at: now, weight: weight})
+	t.subnets.hits[subnet] = hits
+	if sumWeights(hits) > t.subnets.threshold {
+		ban := t.nextBan(subnet, now, ReasonThreshold)
+		t.subnets.banned[subnet] = ban
+		t.notify(BanEvent{Target: subnet, Kind: "subnet", Ban: ban})
+		delete(t.subnets.hits, subnet)
+		log.Printf("Banned subnet: %s until %s (offense #%d)", subnet, ban.Until.Format(time.RFC3339), ban.Offenses)
+	}
+}
+
+// checkSubnetBan returns the ban of the subnet of the ip, caller must hold the lock
+func (t *IPTracker) checkSubnetBan(ip string) (Ban, bool) {
+	subnet := t.subnetOf(ip)
+	if subnet == "" {
+		return Ban{}, false
+	}
+	ban, banned := t.subnets.banned[subnet]
+	if !banned {
+		return Ban{}, false
+	}
+	if time.Now().After(ban.Until) {
+		delete(t.subnets.banned, subnet)
+		return Ban{}, false
+	}
+	return ban, true
+}
+
+// subnetInfo returns the active subnet (and manual range) bans and windowed scores, caller must hold the lock
+func (t *IPTracker) subnetInfo(now time.Time) (map[string]Ban, map[string]float64) {
+	banned := make(map[string]Ban, len(t.subnets.banned))
+	for subnet, ban := range t.subnets.banned {
+		if now.After(ban.Until) {
+			delete(t.subnets.banned, subnet)
+			continue
+		}
+		banned[subnet] = ban
+	}
+	for key, rangeBan := range t.rangeBans {
+		if now.Before(rangeBan.ban.Until) {
+			banned[key] = rangeBan.ban
+		}
+	}
+	scores := make(map[string]float64, len(t.subnets.hits))
+	for subnet := range t.subnets.hits {
+		if hits := pruneWindow(t.subnets.hits, subnet, now.Add(-t.window)); len(hits) > 0 {
+			scores[subnet] = sumWeights(hits)
+		}
+	}
+	return banned, scores
+}
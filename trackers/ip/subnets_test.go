@@ -0,0 +1,31 @@
+package ip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubnetOf(t *testing.T) {
+	tracker := NewIPTracker(10, time.Minute, time.Minute)
+	tracker.SetSubnetAggregation(24, 64, 5)
+
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"198.51.100.7", "198.51.100.0/24"},
+		{"::ffff:198.51.100.7", "198.51.100.0/24"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		if got := tracker.subnetOf(tt.ip); got != tt.expected {
+			t.Errorf("subnetOf(%q) = %q, want %q", tt.ip, got, tt.expected)
+		}
+	}
+
+	tracker.SetSubnetAggregation(24, 64, 0)
+	if got := tracker.subnetOf("198.51.100.7"); got != "" {
+		t.Errorf("subnetOf() = %q, want empty with the aggregation disabled", got)
+	}
+}
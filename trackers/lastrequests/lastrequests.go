@@ -1,6 +1,10 @@
 package lastrequests
 
-import "time"
+import (
+	"reverseproxy/ipinfo"
+	"sync"
+	"time"
+)
 
 // RequestInfo represents a record with URL, status code, and user agent
 type RequestInfo struct {
@@ -10,10 +14,14 @@ type RequestInfo struct {
 	StartTime  time.Time `json:"startTime"`
 	Duration   float64   `json:"duration"`
 	Ip         string    `json:"ip"`
+	Reason     string    `json:"reason,omitempty"`
+	Hosting    string    `json:"hosting,omitempty"`
+	ipinfo.Geo
 }
 
 // RingBuffer is a circular buffer to hold the last x RequestInfo records
 type RingBuffer struct {
+	mu       sync.Mutex
 	buffer   []RequestInfo
 	capacity int
 	head     int
@@ -32,6 +40,8 @@ func NewRingBuffer(capacity int) *RingBuffer {
 
 // Add adds a new RequestInfo to the ring buffer
 func (rb *RingBuffer) Add(record RequestInfo) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
 	rb.buffer[rb.head] = record
 	rb.head = (rb.head + 1) % rb.capacity
 	if rb.size < rb.capacity {
@@ -41,6 +51,8 @@ func (rb *RingBuffer) Add(record RequestInfo) {
 
 // GetAll retrieves all the records in the buffer in order of insertion
 func (rb *RingBuffer) GetAll() []RequestInfo {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
 	result := make([]RequestInfo, rb.size)
 	for i := 0; i < rb.size; i++ {
 		index := (rb.head - rb.size + i + rb.capacity) % rb.capacity
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket refills rate tokens per second up to burst, each request takes one token
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket
+func NewTokenBucket(rate float64, burst float64) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens earned since the last call, caller must hold the lock
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// Allow takes a token if available, otherwise it returns how long to wait for the next one
+func (b *TokenBucket) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// Refund gives back a token taken by Allow, for a request refused by a later limit
+func (b *TokenBucket) Refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// Tokens returns the tokens currently available
+func (b *TokenBucket) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+// isFull reports if the bucket refilled completely, it can then be dropped without loss
+func (b *TokenBucket) isFull() bool {
+	return b.Tokens() >= b.burst
+}
+
+// Limiter keeps a token bucket per key (typically the client ip)
+type Limiter struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	buckets  map[string]*TokenBucket
+	rejected map[string]int
+}
+
+// NewLimiter creates a limiter, a rate of 0 returns nil which allows everything
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{
+		rate:     rate,
+		burst:    math.Max(1, float64(burst)),
+		buckets:  make(map[string]*TokenBucket),
+		rejected: make(map[string]int),
+	}
+}
+
+// Bucket returns the bucket of the key, creating it if necessary
+func (l *Limiter) Bucket(key string) *TokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = NewTokenBucket(l.rate, l.burst)
+		l.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// Allow takes a token from the bucket of the key, see TokenBucket.Allow
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	allowed, retryAfter := l.Bucket(key).Allow()
+	if !allowed {
+		l.mu.Lock()
+		l.rejected[key]++
+		l.mu.Unlock()
+	}
+	return allowed, retryAfter
+}
+
+// Refund gives back the token taken from the bucket of the key, see TokenBucket.Refund
+func (l *Limiter) Refund(key string) {
+	if l == nil {
+		return
+	}
+	l.Bucket(key).Refund()
+}
+
+// Prune drops the buckets that refilled completely along with their rejected count
+func (l *Limiter) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, bucket := range l.buckets {
+		if bucket.isFull() {
+			delete(l.buckets, key)
+			delete(l.rejected, key)
+		}
+	}
+}
+
+// Janitor prunes the limiter every interval until ctx is done
+func (l *Limiter) Janitor(ctx context.Context, interval time.Duration) {
+	if l == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Prune()
+		}
+	}
+}
+
+// Info returns the settings of the limiter and the rejected requests per key still tracked
+func (l *Limiter) Info() map[string]interface{} {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rejected := make(map[string]int, len(l.rejected))
+	for key, count := range l.rejected {
+		rejected[key] = count
+	}
+	return map[string]interface{}{
+		"rate":     l.rate,
+		"burst":    l.burst,
+		"tracked":  len(l.buckets),
+		"rejected": rejected,
+	}
+}
@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Limit is a token bucket setting: rate requests per second with a burst
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RouteLimit configures the limits of a cleaned path template like /api/{id}/export.pdf,
+// PerIP applies to each client ip while Global is shared by all the clients
+type RouteLimit struct {
+	Path   string `json:"path"`
+	PerIP  *Limit `json:"perIp,omitempty"`
+	Global *Limit `json:"global,omitempty"`
+}
+
+type routeLimiter struct {
+	perIP    *Limiter
+	global   *TokenBucket
+	limit    RouteLimit
+	rejected int64
+}
+
+// RouteLimiters holds the limiters of the configured routes
+type RouteLimiters struct {
+	routes map[string]*routeLimiter
+}
+
+// LoadRouteLimits reads a json array of route limits
+func LoadRouteLimits(path string) ([]RouteLimit, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var limits []RouteLimit
+	if err := json.Unmarshal(content, &limits); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, limit := range limits {
+		if limit.Path == "" {
+			return nil, fmt.Errorf("%s: route limit without path", path)
+		}
+	}
+	return limits, nil
+}
+
+// NewRouteLimiters creates the limiters of the routes, nil when there are none
+func NewRouteLimiters(limits []RouteLimit) *RouteLimiters {
+	if len(limits) == 0 {
+		return nil
+	}
+	routes := &RouteLimiters{routes: make(map[string]*routeLimiter)}
+	for _, limit := range limits {
+		route := &routeLimiter{limit: limit}
+		if limit.PerIP != nil {
+			route.perIP = NewLimiter(limit.PerIP.Rate, limit.PerIP.Burst)
+		}
+		if limit.Global != nil && limit.Global.Rate > 0 {
+			route.global = NewTokenBucket(limit.Global.Rate, float64(max(1, limit.Global.Burst)))
+		}
+		routes.routes[limit.Path] = route
+	}
+	return routes
+}
+
+// Allow checks the per ip limit then the global limit of the route, paths without limits are always allowed.
+// The per ip token is given back when the global limit refuses the request, a saturated route
+// doesn't drain the buckets of the clients.
+func (r *RouteLimiters) Allow(path string, ip string) (bool, time.Duration) {
+	if r == nil {
+		return true, 0
+	}
+	route, exists := r.routes[path]
+	if !exists {
+		return true, 0
+	}
+	if allowed, retryAfter := route.perIP.Allow(ip); !allowed {
+		atomic.AddInt64(&route.rejected, 1)
+		return false, retryAfter
+	}
+	if route.global != nil {
+		if allowed, retryAfter := route.global.Allow(); !allowed {
+			route.perIP.Refund(ip)
+			atomic.AddInt64(&route.rejected, 1)
+			return false, retryAfter
+		}
+	}
+	return true, 0
+}
+
+// Info returns the current token levels of the route, nil if the path has no limits
+func (r *RouteLimiters) Info(path string) map[string]interface{} {
+	if r == nil {
+		return nil
+	}
+	route, exists := r.routes[path]
+	if !exists {
+		return nil
+	}
+	info := map[string]interface{}{
+		"rejected": atomic.LoadInt64(&route.rejected),
+	}
+	if route.global != nil {
+		info["globalTokens"] = route.global.Tokens()
+		info["globalBurst"] = route.global.burst
+	}
+	if route.perIP != nil {
+		tokens := make(map[string]float64)
+		route.perIP.mu.Lock()
+		for ip, bucket := range route.perIP.buckets {
+			tokens[ip] = bucket.Tokens()
+		}
+		route.perIP.mu.Unlock()
+		info["perIpTokens"] = tokens
+		info["perIpBurst"] = route.perIP.burst
+	}
+	return info
+}
+
+// Janitor prunes the per ip buckets of the routes every interval until ctx is done
+func (r *RouteLimiters) Janitor(ctx context.Context, interval time.Duration) {
+	if r == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, route := range r.routes {
+				if route.perIP != nil {
+					route.perIP.Prune()
+				}
+			}
+		}
+	}
+}